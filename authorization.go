@@ -0,0 +1,76 @@
+package cqrs
+
+import (
+	"errors"
+	"reflect"
+)
+
+// ErrCommandNotAuthorized is matched by errors returned when an actor is not permitted to issue a command
+var ErrCommandNotAuthorized = errors.New("command not authorized")
+
+// CommandAuthorizationPolicy decides whether an actor may issue a command. Returning an error denies the command
+type CommandAuthorizationPolicy func(actor string, command Command) error
+
+// AuthorizationError is returned when an actor is not permitted to issue a command
+type AuthorizationError struct {
+	Actor       string
+	CommandType string
+	Reason      string
+}
+
+func (e *AuthorizationError) Error() string {
+	return "actor " + e.Actor + " is not authorized to issue command " + e.CommandType + " - " + e.Reason
+}
+
+// Is allows an AuthorizationError to be matched against ErrCommandNotAuthorized using errors.Is
+func (e *AuthorizationError) Is(target error) bool {
+	return target == ErrCommandNotAuthorized
+}
+
+// AllowActors is an authorization policy permitting only the given actors to issue commands
+func AllowActors(actors ...string) CommandAuthorizationPolicy {
+	allowed := make(map[string]bool)
+	for _, actor := range actors {
+		allowed[actor] = true
+	}
+
+	return func(actor string, command Command) error {
+		if !allowed[actor] {
+			return errors.New("actor not allowed")
+		}
+
+		return nil
+	}
+}
+
+// commandAuthorizer evaluates per command type rules followed by a global policy against the actor of a command
+type commandAuthorizer struct {
+	policy CommandAuthorizationPolicy
+	rules  map[string][]CommandAuthorizationPolicy
+}
+
+// authorize evaluates the rules registered for the type of the command body, the type commands are dispatched by,
+// rather than the CommandType supplied by the sender
+func (a *commandAuthorizer) authorize(command Command) error {
+	var policies []CommandAuthorizationPolicy
+	if command.Body != nil {
+		policies = a.rules[reflect.TypeOf(command.Body).String()]
+	}
+	if a.policy != nil {
+		policies = append(policies[:len(policies):len(policies)], a.policy)
+	}
+
+	for _, policy := range policies {
+		if err := policy(command.Actor, command); err != nil {
+			metricsCommandsUnauthorized.WithLabelValues(command.CommandType).Inc()
+			var authorizationErr *AuthorizationError
+			if errors.As(err, &authorizationErr) {
+				return authorizationErr
+			}
+
+			return &AuthorizationError{Actor: command.Actor, CommandType: command.CommandType, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
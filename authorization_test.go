@@ -0,0 +1,80 @@
+package cqrs_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type CloseLedgerCommand struct {
+	LedgerID string
+}
+
+func TestCommandDispatchManagerAuthorization(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	repository := cqrs.NewRepository(cqrs.NewInMemoryEventStreamRepository(), typeRegistry)
+	bus := cqrs.NewInMemoryCommandBus()
+	bus.SetCommandTimeout(5 * time.Second)
+
+	manager := cqrs.NewCommandDispatchManager(bus, typeRegistry)
+	manager.SetErrorRepository(repository)
+	manager.SetAuthorizationPolicy(func(actor string, command cqrs.Command) error {
+		if len(actor) == 0 {
+			return errors.New("anonymous actor")
+		}
+
+		return nil
+	})
+	manager.AuthorizeCommand(CloseLedgerCommand{}, cqrs.AllowActors("auditor"))
+	rejections := make(chan error, 1)
+	manager.OnError(func(err *cqrs.ReceiverError) { rejections <- err })
+
+	if _, err := manager.RegisterCommandHandler(CloseLedgerCommand{}, func(command cqrs.Command) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan bool)
+	if _, err := manager.Listen(stop, false, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	denied := cqrs.CreateCommand(CloseLedgerCommand{"l1"})
+	denied.Actor = "john.snow"
+	result, err := bus.SendCommand(denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Success {
+		t.Fatal("Expected command issued by an unauthorized actor to be denied")
+	}
+
+	if err := <-rejections; !errors.Is(err, cqrs.ErrCommandNotAuthorized) || !cqrs.IsPermanent(err) {
+		t.Fatal("Expected the denial to fail permanently but got", err)
+	}
+
+	events, err := repository.GetEventStreamRepository().GetIntegrationEventsByCorrelationID(denied.CorrelationID)
+	if err != nil || len(events) != 1 || events[0].EventType != cqrs.CQRSErrorEventType {
+		t.Fatal("Expected the denial to be recorded against the correlation ID", events, err)
+	}
+
+	// Rules apply to the type of the command body however the sender names the command type
+	mislabelled := cqrs.Command{MessageID: "mid:" + cqrs.NewUUIDString(), CorrelationID: "cid:" + cqrs.NewUUIDString(), Actor: "mallory", CommandType: "CloseLedger", Created: time.Now(), Body: CloseLedgerCommand{"l1"}}
+	if result, err = bus.SendCommand(mislabelled); err != nil || result.Success {
+		t.Fatal("Expected a command with a mismatched command type to be denied", result, err)
+	}
+
+	if err := <-rejections; !errors.Is(err, cqrs.ErrCommandNotAuthorized) {
+		t.Fatal("Expected the mislabelled command to be denied but got", err)
+	}
+
+	allowed := cqrs.CreateCommand(CloseLedgerCommand{"l1"})
+	allowed.Actor = "auditor"
+	if result, err = bus.SendCommand(allowed); err != nil || !result.Success {
+		t.Fatal("Expected command issued by an authorized actor to be accepted", result, err)
+	}
+
+	stop <- true
+}
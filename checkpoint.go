@@ -0,0 +1,274 @@
+package cqrs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCheckpointingNotConfigured is returned when catching up a dispatch manager without checkpointing
+var ErrCheckpointingNotConfigured = errors.New("checkpointing is not configured")
+
+// ErrCheckpointStuck is reported when an event that failed was not redelivered before DefaultCheckpointPendingLimit later events
+// were received, holding the checkpoint back until the subscriber restarts
+var ErrCheckpointStuck = errors.New("checkpoint held back by an event that was not redelivered")
+
+// DefaultCheckpointPendingLimit is the number of received events the checkpoint can be held back by before it is stuck.
+// A stuck checkpoint stops tracking received events, bounding memory, and a restarted subscriber replays the events since it
+var DefaultCheckpointPendingLimit = 10000
+
+// catchUpDedupeLimit bounds how many of the last events handled while catching up are remembered to skip their live duplicates.
+// Only events published shortly before subscribing to live events can be received both from the log and live
+const catchUpDedupeLimit = 10000
+
+// Checkpoint records how far a consumer has handled the integration event log.
+// Position counts the events handled from the start of the log and EventID identifies the last handled event
+type Checkpoint struct {
+	Position int       `json:"position"`
+	EventID  string    `json:"eventID"`
+	Updated  time.Time `json:"updated"`
+}
+
+// CheckpointStore is responsible for persisting the checkpoints of named event consumers such as projections
+type CheckpointStore interface {
+	SaveCheckpoint(name string, checkpoint Checkpoint) error
+	GetCheckpoint(name string) (Checkpoint, error)
+}
+
+// advance returns the checkpoint after handling the event with the given ID
+func (c Checkpoint) advance(eventID string) Checkpoint {
+	return Checkpoint{Position: c.Position + 1, EventID: eventID, Updated: time.Now().UTC()}
+}
+
+// resumeIndex returns the index in the event log of the first event not yet handled according to the checkpoint.
+// The position is trusted when the event it points at matches the checkpoint's event ID, otherwise the event is looked up by ID
+func (c Checkpoint) resumeIndex(log []VersionedEvent) int {
+	if c.Position == 0 {
+		return 0
+	}
+
+	if c.Position <= len(log) && (c.EventID == "" || log[c.Position-1].ID == c.EventID) {
+		return c.Position
+	}
+
+	for i, event := range log {
+		if event.ID == c.EventID {
+			return i + 1
+		}
+	}
+
+	if c.Position > len(log) {
+		return len(log)
+	}
+
+	return c.Position
+}
+
+// eventCheckpointer tracks the checkpoint of a named subscriber, committing it to the store in batches.
+// Events are counted in the order they are received, and the checkpoint only advances over events handled without a gap so it never
+// passes an event that failed. A failed event holds the checkpoint back until its redelivery is handled, so a restarted subscriber
+// resumes from it. Checkpointing therefore needs a receiver redelivering failed events, otherwise the checkpoint gets stuck once
+// DefaultCheckpointPendingLimit events were received after the failure. Events handled while catching up are remembered by ID to
+// skip their live duplicates
+type eventCheckpointer struct {
+	lock        sync.Mutex
+	name        string
+	store       CheckpointStore
+	batchSize   int
+	checkpoint  Checkpoint
+	uncommitted int
+	pending     []*pendingCheckpoint
+	pendingByID map[string]*pendingCheckpoint
+	caughtUp    map[string]bool
+	// stuck is set once the checkpoint was held back by too many events, after which received events are no longer tracked
+	stuck   bool
+	onStuck func(error)
+}
+
+// pendingCheckpoint is an event received by the subscriber that the checkpoint did not pass yet
+type pendingCheckpoint struct {
+	eventID string
+	handled bool
+}
+
+func newEventCheckpointer(name string, store CheckpointStore, batchSize int) *eventCheckpointer {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &eventCheckpointer{name: name, store: store, batchSize: batchSize, pendingByID: make(map[string]*pendingCheckpoint), caughtUp: make(map[string]bool)}
+}
+
+// load reads the persisted checkpoint of the subscriber, forgetting events received before
+func (c *eventCheckpointer) load() (Checkpoint, error) {
+	checkpoint, err := c.store.GetCheckpoint(c.name)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checkpoint = checkpoint
+	c.uncommitted = 0
+	c.pending = nil
+	c.pendingByID = make(map[string]*pendingCheckpoint)
+	c.caughtUp = make(map[string]bool)
+	c.stuck = false
+
+	return checkpoint, nil
+}
+
+// reportStuck sets the function reporting ErrCheckpointStuck
+func (c *eventCheckpointer) reportStuck(report func(error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.onStuck = report
+}
+
+// current returns the checkpoint of the events handled so far
+func (c *eventCheckpointer) current() Checkpoint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.checkpoint
+}
+
+// catchUp handles the events of the log since the checkpoint in order, stopping at the first event that fails.
+// The last events handled are remembered so their live duplicates can be skipped
+func (c *eventCheckpointer) catchUp(log []VersionedEvent, handle VersionedEventHandler) error {
+	start := c.current().resumeIndex(log)
+	remember := len(log) - catchUpDedupeLimit
+	for i := start; i < len(log); i++ {
+		event := log[i]
+		if err := c.dispatch(event, handle); err != nil {
+			return err
+		}
+
+		if i >= remember && event.ID != "" {
+			c.lock.Lock()
+			c.caughtUp[event.ID] = true
+			c.lock.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// dispatch handles an event and advances the checkpoint once every event received before it was handled as well
+func (c *eventCheckpointer) dispatch(event VersionedEvent, handle VersionedEventHandler) error {
+	pending := c.received(event)
+	if err := handle(event); err != nil {
+		return err
+	}
+
+	return c.handled(pending)
+}
+
+// received records an event in the order it was received. The redelivery of an event that did not pass the checkpoint yet
+// takes the place of its earlier delivery. Once the checkpoint is stuck events are no longer recorded and nil is returned
+func (c *eventCheckpointer) received(event VersionedEvent) *pendingCheckpoint {
+	c.lock.Lock()
+	if pending, ok := c.pendingByID[event.ID]; ok && event.ID != "" {
+		c.lock.Unlock()
+		return pending
+	}
+
+	if c.stuck {
+		c.lock.Unlock()
+		return nil
+	}
+
+	if len(c.pending) >= DefaultCheckpointPendingLimit {
+		c.stuck = true
+		report := c.onStuck
+		held := c.pending[0].eventID
+		c.lock.Unlock()
+
+		err := fmt.Errorf("%w for %s at %s", ErrCheckpointStuck, c.name, held)
+		PackageLogger().Debugf("eventCheckpointer.Stuck: %v", err)
+		if report != nil {
+			report(err)
+		}
+
+		return nil
+	}
+
+	pending := &pendingCheckpoint{eventID: event.ID}
+	c.pending = append(c.pending, pending)
+	if event.ID != "" {
+		c.pendingByID[event.ID] = pending
+	}
+	c.lock.Unlock()
+
+	return pending
+}
+
+// handled marks a received event as handled and advances the checkpoint over the events handled without a gap,
+// committing it once a batch of events was passed. Events received while the checkpoint is stuck are ignored
+func (c *eventCheckpointer) handled(handled *pendingCheckpoint) error {
+	if handled == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	handled.handled = true
+	for len(c.pending) > 0 && c.pending[0].handled {
+		pending := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		delete(c.pendingByID, pending.eventID)
+		c.checkpoint = c.checkpoint.advance(pending.eventID)
+		c.uncommitted++
+	}
+
+	if c.uncommitted < c.batchSize {
+		return nil
+	}
+
+	return c.commitLocked()
+}
+
+// handledWhileCatchingUp reports whether a live event was already handled while catching up.
+// Live events are delivered in the order of the log, so once a live event was not caught up every remembered event has been
+// delivered live and they are forgotten
+func (c *eventCheckpointer) handledWhileCatchingUp(event VersionedEvent) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.caughtUp) == 0 {
+		return false
+	}
+
+	if c.caughtUp[event.ID] {
+		delete(c.caughtUp, event.ID)
+		return true
+	}
+
+	c.caughtUp = make(map[string]bool)
+	return false
+}
+
+// commit persists the checkpoint when events were handled since the last commit
+func (c *eventCheckpointer) commit() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.uncommitted == 0 {
+		return nil
+	}
+
+	return c.commitLocked()
+}
+
+func (c *eventCheckpointer) commitLocked() error {
+	if err := c.store.SaveCheckpoint(c.name, c.checkpoint); err != nil {
+		return err
+	}
+
+	c.uncommitted = 0
+	return nil
+}
@@ -0,0 +1,232 @@
+package cqrs_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store, err := cqrs.NewFileCheckpointStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SaveCheckpoint("accounts", cqrs.Checkpoint{Position: 42, EventID: "event-42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := cqrs.NewFileCheckpointStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint, _ := reloaded.GetCheckpoint("accounts"); checkpoint.Position != 42 || checkpoint.EventID != "event-42" {
+		t.Fatal("Expected the checkpoint to be loaded from disk but got", checkpoint)
+	}
+
+	if checkpoint, _ := reloaded.GetCheckpoint("users"); checkpoint.Position != 0 {
+		t.Fatal("Expected an unknown subscriber to start from the beginning but got", checkpoint)
+	}
+}
+
+func TestVersionedEventDispatchManagerResumesFromCheckpoint(t *testing.T) {
+	log := cqrs.NewInMemoryEventStreamRepository()
+	checkpoints, err := cqrs.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(ids ...string) {
+		for _, id := range ids {
+			_ = log.SaveIntegrationEvent(cqrs.VersionedEvent{ID: id, Created: time.Now(), Event: SampleMessageReceivedEvent{id}})
+		}
+	}
+
+	catchUp := func() []string {
+		var dispatched []string
+		manager := cqrs.NewVersionedEventDispatchManager(cqrs.NewInMemoryEventBus(), cqrs.NewTypeRegistry())
+		manager.SetCheckpointing("accounts", checkpoints, 2)
+		manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+			dispatched = append(dispatched, event.ID)
+			return nil
+		})
+
+		if err := manager.CatchUp(log); err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := manager.Listen(nil, false, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := listener.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		return dispatched
+	}
+
+	save("first", "second", "third")
+	if dispatched := catchUp(); len(dispatched) != 3 {
+		t.Fatal("Expected every stored event to be dispatched but got", dispatched)
+	}
+
+	save("fourth")
+	if dispatched := catchUp(); len(dispatched) != 1 || dispatched[0] != "fourth" {
+		t.Fatal("Expected only the events stored since the checkpoint to be dispatched but got", dispatched)
+	}
+
+	if checkpoint, _ := checkpoints.GetCheckpoint("accounts"); checkpoint.Position != 4 || checkpoint.EventID != "fourth" {
+		t.Fatal("Expected the final checkpoint to be committed but got", checkpoint)
+	}
+}
+
+func TestVersionedEventDispatchManagerCheckpointStopsAtFailedLiveEvent(t *testing.T) {
+	log := cqrs.NewInMemoryEventStreamRepository()
+	checkpoints, err := cqrs.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := func(id string) cqrs.VersionedEvent {
+		return cqrs.VersionedEvent{ID: id, Created: time.Now(), Event: SampleMessageReceivedEvent{id}}
+	}
+
+	_ = log.SaveIntegrationEvent(event("caught-up"))
+
+	bus := cqrs.NewInMemoryEventBus()
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+	manager.SetCheckpointing("accounts", checkpoints, 1)
+	dispatched := make(chan string, 10)
+	failed := false
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		dispatched <- event.ID
+		if event.ID == "second" && !failed {
+			failed = true
+			return errors.New("second failed")
+		}
+
+		return nil
+	})
+
+	if err := manager.CatchUp(log); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := <-dispatched; id != "caught-up" {
+		t.Fatal("Expected the stored event to be caught up but got", id)
+	}
+
+	listener, err := manager.Listen(nil, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(ids ...string) {
+		for _, id := range ids {
+			if err := bus.PublishEvents([]cqrs.VersionedEvent{event(id)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	receive := func(expected ...string) {
+		for _, id := range expected {
+			select {
+			case received := <-dispatched:
+				if received != id {
+					t.Fatal("Expected", id, "to be dispatched but got", received)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Timed out waiting for", id)
+			}
+		}
+	}
+
+	// The live duplicate of the caught up event is skipped
+	publish("caught-up", "first", "second", "third")
+	receive("first", "second", "third")
+
+	// Publishing waits for the previous event to be handled by the single listener
+	publish("fourth")
+	receive("fourth")
+	publish("fifth")
+	receive("fifth")
+	if checkpoint, _ := checkpoints.GetCheckpoint("accounts"); checkpoint.Position != 2 || checkpoint.EventID != "first" {
+		t.Fatal("Expected the checkpoint to stop before the failed event but got", checkpoint)
+	}
+
+	publish("second")
+	receive("second")
+	if err := listener.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint, _ := checkpoints.GetCheckpoint("accounts"); checkpoint.Position != 6 || checkpoint.EventID != "fifth" {
+		t.Fatal("Expected the checkpoint to pass the redelivered event but got", checkpoint)
+	}
+}
+
+func TestVersionedEventDispatchManagerReportsStuckCheckpoint(t *testing.T) {
+	defer func(limit int) { cqrs.DefaultCheckpointPendingLimit = limit }(cqrs.DefaultCheckpointPendingLimit)
+	cqrs.DefaultCheckpointPendingLimit = 2
+
+	checkpoints := cqrs.NewInMemoryCheckpointStore()
+	bus := cqrs.NewInMemoryEventBus()
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+	manager.SetCheckpointing("accounts", checkpoints, 1)
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		if event.ID == "failing" {
+			return errors.New("failed")
+		}
+
+		return nil
+	})
+
+	stuck := make(chan *cqrs.ReceiverError, 10)
+	manager.OnError(func(err *cqrs.ReceiverError) {
+		if err.Kind == cqrs.ReceiverErrorCheckpoint {
+			stuck <- err
+		}
+	})
+
+	listener, err := manager.Listen(nil, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The in memory bus dead letters the failed event instead of redelivering it
+	for _, id := range []string{"first", "failing", "second", "third", "fourth"} {
+		if err := bus.PublishEvents([]cqrs.VersionedEvent{{ID: id, Created: time.Now(), Event: SampleMessageReceivedEvent{id}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-stuck:
+		if !errors.Is(err, cqrs.ErrCheckpointStuck) {
+			t.Fatal("Expected the stuck checkpoint to be reported but got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the stuck checkpoint to be reported")
+	}
+
+	if err := listener.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stuck) != 0 {
+		t.Fatal("Expected the stuck checkpoint to be reported once")
+	}
+
+	if checkpoint, _ := checkpoints.GetCheckpoint("accounts"); checkpoint.Position != 1 || checkpoint.EventID != "first" {
+		t.Fatal("Expected the checkpoint to stay before the failed event but got", checkpoint)
+	}
+}
@@ -0,0 +1,112 @@
+package cqrs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrScheduledCommandNotFound is returned when a scheduled command cannot be found, for example once it has been delivered or cancelled
+var ErrScheduledCommandNotFound = errors.New("scheduled command not found")
+
+// DefaultSchedulerPollInterval is the default interval at which a command scheduler checks for due commands
+var DefaultSchedulerPollInterval = time.Second
+
+// ScheduledCommand is a command that will be published once it is due
+type ScheduledCommand struct {
+	ID      string    `json:"id"`
+	Due     time.Time `json:"due"`
+	Command Command   `json:"command"`
+}
+
+// ScheduledCommandStore is a durable store for commands awaiting delivery
+type ScheduledCommandStore interface {
+	SaveScheduledCommand(ScheduledCommand) error
+	RemoveScheduledCommand(id string) error
+	GetDueScheduledCommands(time.Time) ([]ScheduledCommand, error)
+}
+
+// CommandScheduler is responsible for publishing scheduled commands to a command publisher once they are due
+type CommandScheduler struct {
+	store        ScheduledCommandStore
+	publisher    CommandPublisher
+	pollInterval time.Duration
+	lock         sync.Mutex
+}
+
+// NewCommandScheduler is a constructor for the CommandScheduler
+func NewCommandScheduler(store ScheduledCommandStore, publisher CommandPublisher) *CommandScheduler {
+	return &CommandScheduler{store: store, publisher: publisher, pollInterval: DefaultSchedulerPollInterval}
+}
+
+// SetPollInterval sets the interval at which the scheduler checks for due commands
+func (s *CommandScheduler) SetPollInterval(interval time.Duration) {
+	s.pollInterval = interval
+}
+
+// ScheduleCommand persists a command to be published at the given time and returns the ID of the scheduled command
+func (s *CommandScheduler) ScheduleCommand(command Command, at time.Time) (string, error) {
+	scheduled := ScheduledCommand{ID: "sc:" + NewUUIDString(), Due: at.UTC(), Command: command}
+	if err := s.store.SaveScheduledCommand(scheduled); err != nil {
+		return "", err
+	}
+
+	PackageLogger().Debugf("CommandScheduler.Scheduled: %s %s at %v", scheduled.ID, command.CommandType, scheduled.Due)
+	return scheduled.ID, nil
+}
+
+// CancelScheduledCommand cancels a scheduled command that has not been published yet
+func (s *CommandScheduler) CancelScheduledCommand(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.RemoveScheduledCommand(id)
+}
+
+// PublishDueCommands publishes all commands due at the given time and removes them from the store.
+// Commands are removed only once published, giving at least once delivery
+func (s *CommandScheduler) PublishDueCommands(now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	due, err := s.store.GetDueScheduledCommands(now)
+	if err != nil {
+		return err
+	}
+
+	for _, scheduled := range due {
+		if err := s.publisher.PublishCommands([]Command{scheduled.Command}); err != nil {
+			return err
+		}
+
+		if err := s.store.RemoveScheduledCommand(scheduled.ID); err != nil {
+			return err
+		}
+
+		PackageLogger().Debugf("CommandScheduler.Published: %s %s", scheduled.ID, scheduled.Command.CommandType)
+	}
+
+	return nil
+}
+
+// Listen starts a go routine publishing due commands until a stop request is received
+func (s *CommandScheduler) Listen(stop <-chan bool) error {
+	go func() {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				PackageLogger().Debugf("CommandScheduler.Stopped")
+				return
+			case now := <-ticker.C:
+				if err := s.PublishDueCommands(now); err != nil {
+					PackageLogger().Debugf("CommandScheduler.ErrorPublishing: %v", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
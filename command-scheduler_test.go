@@ -0,0 +1,97 @@
+package cqrs_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type ExpireReservationCommand struct {
+	ReservationID string
+}
+
+type recordingCommandPublisher struct {
+	commands []cqrs.Command
+}
+
+func (p *recordingCommandPublisher) PublishCommands(commands []cqrs.Command) error {
+	p.commands = append(p.commands, commands...)
+	return nil
+}
+
+func TestCommandScheduler(t *testing.T) {
+	publisher := &recordingCommandPublisher{}
+	scheduler := cqrs.NewCommandScheduler(cqrs.NewInMemoryScheduledCommandStore(), publisher)
+
+	now := time.Now()
+	expire := cqrs.CreateCommand(ExpireReservationCommand{"r1"})
+	if _, err := scheduler.ScheduleCommand(expire, now.Add(15*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, err := scheduler.ScheduleCommand(cqrs.CreateCommand(ExpireReservationCommand{"r2"}), now.Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheduler.CancelScheduledCommand(cancelled); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheduler.CancelScheduledCommand(cancelled); err != cqrs.ErrScheduledCommandNotFound {
+		t.Fatal("Expected cancelled command to be removed but got", err)
+	}
+
+	if err := scheduler.PublishDueCommands(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(publisher.commands) != 0 {
+		t.Fatal("Expected no commands to be due yet")
+	}
+
+	if err := scheduler.PublishDueCommands(now.Add(20 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(publisher.commands) != 1 || publisher.commands[0].MessageID != expire.MessageID {
+		t.Fatal("Expected the due command to be published", publisher.commands)
+	}
+}
+
+func TestFileScheduledCommandStore(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	typeRegistry.RegisterType(ExpireReservationCommand{})
+	path := filepath.Join(t.TempDir(), "scheduled.json")
+
+	store, err := cqrs.NewFileScheduledCommandStore(path, typeRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Now().Add(time.Minute)
+	command := cqrs.CreateCommand(ExpireReservationCommand{"r1"})
+	if err := store.SaveScheduledCommand(cqrs.ScheduledCommand{ID: "sc:1", Due: due, Command: command}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := cqrs.NewFileScheduledCommandStore(path, typeRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands, err := reopened.GetDueScheduledCommands(due)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commands) != 1 || commands[0].Command.Body.(ExpireReservationCommand).ReservationID != "r1" {
+		t.Fatal("Expected scheduled command to survive a restart", commands)
+	}
+
+	if err := reopened.RemoveScheduledCommand("sc:1"); err != nil {
+		t.Fatal(err)
+	}
+}
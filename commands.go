@@ -1,15 +1,35 @@
 package cqrs
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"time"
 )
 
+// ErrCommandHandlerAlreadyRegistered is returned by a strict command dispatcher when a second handler is registered for a command type
+var ErrCommandHandlerAlreadyRegistered = errors.New("command handler already registered")
+
+// ErrUnhandledCommand is returned by a strict command dispatcher when no handler is registered for a command type
+var ErrUnhandledCommand = errors.New("no command handler registered")
+
+// ErrCommandTimeout is returned when a sent command's result is not received in time
+var ErrCommandTimeout = errors.New("timeout waiting for command result")
+
+// ErrCommandFailed is returned by CommandResult.Err for failed commands whose result carries no error message
+var ErrCommandFailed = errors.New("command failed")
+
+// DefaultCommandTimeout is the default duration a command sender waits for a command result
+var DefaultCommandTimeout = 30 * time.Second
+
 // Command represents an actor intention to alter the state of the system
 type Command struct {
 	MessageID     string    `json:"messageID"`
 	CorrelationID string    `json:"correlationID"`
 	CommandType   string    `json:"commandType"`
+	Actor         string    `json:"actor"`
+	OnBehalfOf    string    `json:"onbehalfof"`
 	Created       time.Time `json:"time"`
 	Body          interface{}
 }
@@ -40,6 +60,43 @@ type CommandPublisher interface {
 	PublishCommands([]Command) error
 }
 
+// CommandSender is responsible for sending a command and waiting for the outcome of its command handlers
+type CommandSender interface {
+	SendCommand(Command) (CommandResult, error)
+}
+
+// CommandResult represents the outcome of a command after it has been processed by the command handlers
+type CommandResult struct {
+	MessageID     string `json:"messageID"`
+	CorrelationID string `json:"correlationID"`
+	Success       bool   `json:"success"`
+	Error         string `json:"error,omitempty"`
+}
+
+// NewCommandResult is a helper for creating the result of processing the given command
+func NewCommandResult(command Command, err error) CommandResult {
+	result := CommandResult{MessageID: command.MessageID, CorrelationID: command.CorrelationID, Success: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Err returns the error reported by the command handlers or nil if the command was processed successfully.
+// Failures reported without an error message are returned as ErrCommandFailed
+func (r CommandResult) Err() error {
+	if r.Success {
+		return nil
+	}
+
+	if len(r.Error) == 0 {
+		return fmt.Errorf("%w: %s", ErrCommandFailed, r.MessageID)
+	}
+
+	return errors.New(r.Error)
+}
+
 // CommandReceiver is responsible for receiving commands
 type CommandReceiver interface {
 	ReceiveCommands(CommandReceiverOptions) error
@@ -56,6 +113,10 @@ type CommandDispatchManager struct {
 	commandDispatcher *MapBasedCommandDispatcher
 	typeRegistry      TypeRegistry
 	receiver          CommandReceiver
+	middleware        []CommandMiddleware
+	errorRepository   EventSourcingRepository
+	authorizer        commandAuthorizer
+	observers         []ReceiverErrorObserver
 }
 
 // CommandDispatcher the internal command dispatcher
@@ -83,8 +144,8 @@ type CommandTransactedAccept struct {
 // CommandDispatcher is responsible for routing commands from the command manager to call handlers responsible for processing received commands
 type CommandDispatcher interface {
 	DispatchCommand(Command) error
-	RegisterCommandHandler(event interface{}, handler CommandHandler)
-	RegisterGlobalHandler(handler CommandHandler)
+	RegisterCommandHandler(command interface{}, handler CommandHandler) (Subscription, error)
+	RegisterGlobalHandler(handler CommandHandler) Subscription
 }
 
 // CommandHandler is a function that takes a command
@@ -92,51 +153,124 @@ type CommandHandler func(Command) error
 
 // MapBasedCommandDispatcher is a simple implementation of the command dispatcher. Using a map it registered command handlers to command types
 type MapBasedCommandDispatcher struct {
-	registry       map[reflect.Type][]CommandHandler
-	globalHandlers []CommandHandler
+	lock           sync.RWMutex
+	registry       map[reflect.Type][]commandHandlerEntry
+	globalHandlers []commandHandlerEntry
+	nextHandlerID  int
+	strict         bool
+}
+
+type commandHandlerEntry struct {
+	id      int
+	handler CommandHandler
 }
 
 // NewMapBasedCommandDispatcher is a constructor for the MapBasedVersionedCommandDispatcher
 func NewMapBasedCommandDispatcher() *MapBasedCommandDispatcher {
-	registry := make(map[reflect.Type][]CommandHandler)
-	return &MapBasedCommandDispatcher{registry, []CommandHandler{}}
+	registry := make(map[reflect.Type][]commandHandlerEntry)
+	return &MapBasedCommandDispatcher{registry: registry}
+}
+
+// SetStrict enables or disables strict mode. In strict mode each command type is owned by a single command handler
+// and dispatching a command without a registered command handler fails permanently with ErrUnhandledCommand
+func (m *MapBasedCommandDispatcher) SetStrict(strict bool) {
+	m.strict = strict
 }
 
-// RegisterCommandHandler allows a caller to register a command handler given a command of the specified type being received
-func (m *MapBasedCommandDispatcher) RegisterCommandHandler(command interface{}, handler CommandHandler) {
+// RegisterCommandHandler allows a caller to register a command handler given a command of the specified type being received.
+// In strict mode registering a second command handler for the same command type returns ErrCommandHandlerAlreadyRegistered.
+// The returned subscription removes the handler again
+func (m *MapBasedCommandDispatcher) RegisterCommandHandler(command interface{}, handler CommandHandler) (Subscription, error) {
 	commandType := reflect.TypeOf(command)
-	handlers, ok := m.registry[commandType]
-	if ok {
-		m.registry[commandType] = append(handlers, handler)
-	} else {
-		m.registry[commandType] = []CommandHandler{handler}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.registry[commandType]; ok && m.strict {
+		return nil, fmt.Errorf("%w for %s", ErrCommandHandlerAlreadyRegistered, commandType)
 	}
+
+	entry := m.newHandlerEntry(handler)
+	m.registry[commandType] = append(m.registry[commandType], entry)
+
+	return newSubscription(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if handlers := removeCommandHandler(m.registry[commandType], entry.id); len(handlers) > 0 {
+			m.registry[commandType] = handlers
+		} else {
+			delete(m.registry, commandType)
+		}
+	}), nil
 }
 
-// RegisterGlobalHandler allows a caller to register a wildcard command handler call on any command received
-func (m *MapBasedCommandDispatcher) RegisterGlobalHandler(handler CommandHandler) {
-	m.globalHandlers = append(m.globalHandlers, handler)
+// RegisterGlobalHandler allows a caller to register a wildcard command handler call on any command received.
+// The returned subscription removes the handler again
+func (m *MapBasedCommandDispatcher) RegisterGlobalHandler(handler CommandHandler) Subscription {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry := m.newHandlerEntry(handler)
+	m.globalHandlers = append(m.globalHandlers, entry)
+
+	return newSubscription(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		m.globalHandlers = removeCommandHandler(m.globalHandlers, entry.id)
+	})
+}
+
+func (m *MapBasedCommandDispatcher) newHandlerEntry(handler CommandHandler) commandHandlerEntry {
+	m.nextHandlerID++
+	return commandHandlerEntry{m.nextHandlerID, handler}
+}
+
+// removeCommandHandler returns a copy of the handlers without the handler with the given id.
+// Handlers are copied so dispatches already running keep a consistent list
+func removeCommandHandler(handlers []commandHandlerEntry, id int) []commandHandlerEntry {
+	remaining := make([]commandHandlerEntry, 0, len(handlers))
+	for _, entry := range handlers {
+		if entry.id != id {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	return remaining
 }
 
 // DispatchCommand executes all command handlers registered for the given command type
 func (m *MapBasedCommandDispatcher) DispatchCommand(command Command) error {
 	bodyType := reflect.TypeOf(command.Body)
-	if handlers, ok := m.registry[bodyType]; ok {
-		for _, handler := range handlers {
-			if err := handler(command); err != nil {
+	m.lock.RLock()
+	handlers, handled := m.registry[bodyType]
+	globalHandlers := m.globalHandlers
+	m.lock.RUnlock()
+
+	if !handled && m.strict {
+		metricsCommandsUnhandled.WithLabelValues(command.CommandType).Inc()
+		return NewPermanentError(fmt.Errorf("%w for %s", ErrUnhandledCommand, command.CommandType))
+	}
+
+	if handled {
+		for _, entry := range handlers {
+			if err := entry.handler(command); err != nil {
 				metricsCommandsFailed.WithLabelValues(command.CommandType).Inc()
-				return err
+				return &HandlerError{handlerName(entry.handler), err}
 			}
 		}
 	}
 
-	for _, handler := range m.globalHandlers {
-		if err := handler(command); err != nil {
+	for _, entry := range globalHandlers {
+		if err := entry.handler(command); err != nil {
 			metricsCommandsFailed.WithLabelValues(command.CommandType).Inc()
-			return err
+			return &HandlerError{handlerName(entry.handler), err}
 		}
 	}
 
+	if !handled {
+		PackageLogger().Debugf("MapBasedCommandDispatcher.Unhandled: %s", command.CommandType)
+		metricsCommandsUnhandled.WithLabelValues(command.CommandType).Inc()
+		return nil
+	}
+
 	metricsCommandsDispatched.WithLabelValues(command.CommandType).Inc()
 
 	return nil
@@ -144,36 +278,112 @@ func (m *MapBasedCommandDispatcher) DispatchCommand(command Command) error {
 
 // NewCommandDispatchManager is a constructor for the CommandDispatchManager
 func NewCommandDispatchManager(receiver CommandReceiver, registry TypeRegistry) *CommandDispatchManager {
-	return &CommandDispatchManager{NewMapBasedCommandDispatcher(), registry, receiver}
+	return &CommandDispatchManager{commandDispatcher: NewMapBasedCommandDispatcher(), typeRegistry: registry, receiver: receiver}
+}
+
+// Use appends middleware wrapping the dispatch of every received command. The first middleware registered is the outermost.
+// Middleware must be registered before calling Listen
+func (m *CommandDispatchManager) Use(middleware ...CommandMiddleware) {
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// SetErrorRepository sets the repository used to record rejected commands as CQRS error events against their correlation ID.
+// See DeliverCQRSError
+func (m *CommandDispatchManager) SetErrorRepository(repository EventSourcingRepository) {
+	m.errorRepository = repository
+}
+
+// SetAuthorizationPolicy sets a policy evaluated against the actor of every command before it is dispatched.
+// Denied commands are recorded as CQRS error events against their correlation ID
+func (m *CommandDispatchManager) SetAuthorizationPolicy(policy CommandAuthorizationPolicy) {
+	m.authorizer.policy = policy
+}
+
+// AuthorizeCommand adds an authorization rule evaluated against the actor of commands of the specified type before they are dispatched.
+// Rules are evaluated before the authorization policy set with SetAuthorizationPolicy
+func (m *CommandDispatchManager) AuthorizeCommand(command interface{}, rule CommandAuthorizationPolicy) {
+	if m.authorizer.rules == nil {
+		m.authorizer.rules = make(map[string][]CommandAuthorizationPolicy)
+	}
+
+	commandType := reflect.TypeOf(command).String()
+	m.authorizer.rules[commandType] = append(m.authorizer.rules[commandType], rule)
+}
+
+// dispatchCommand authorizes and validates a command before dispatching it to the registered command handlers.
+// Unauthorized and invalid commands are rejected with a PermanentError so receivers do not redeliver them
+func (m *CommandDispatchManager) dispatchCommand(command Command) error {
+	if err := m.authorizer.authorize(command); err != nil {
+		m.rejectCommand(command, err)
+		return NewPermanentError(err)
+	}
+
+	if err := ValidateCommand(command); err != nil {
+		m.rejectCommand(command, err)
+		return NewPermanentError(err)
+	}
+
+	err := m.commandDispatcher.DispatchCommand(command)
+	if errors.Is(err, ErrUnhandledCommand) {
+		m.rejectCommand(command, err)
+	}
+
+	return err
+}
+
+// rejectCommand records why a command was rejected against its correlation ID
+func (m *CommandDispatchManager) rejectCommand(command Command, err error) {
+	PackageLogger().Debugf("CommandDispatchManager.Rejected: %v %v", command.CorrelationID, err)
+	if m.errorRepository != nil {
+		DeliverCQRSError(command.CorrelationID, err, m.errorRepository)
+	}
 }
 
-// RegisterCommandHandler allows a caller to register a command handler given a command of the specified type being received
-func (m *CommandDispatchManager) RegisterCommandHandler(command interface{}, handler CommandHandler) {
+// OnError registers an observer called with every error reported while receiving and dispatching commands, including
+// deserialization failures, unknown command types, handler failures and lost connections.
+// Observers must be registered before calling Listen
+func (m *CommandDispatchManager) OnError(observer ReceiverErrorObserver) {
+	m.observers = append(m.observers, observer)
+}
+
+// SetStrict enables or disables strict handler ownership. See MapBasedCommandDispatcher.SetStrict
+func (m *CommandDispatchManager) SetStrict(strict bool) {
+	m.commandDispatcher.SetStrict(strict)
+}
+
+// RegisterCommandHandler allows a caller to register a command handler given a command of the specified type being received.
+// The returned subscription removes the handler again
+func (m *CommandDispatchManager) RegisterCommandHandler(command interface{}, handler CommandHandler) (Subscription, error) {
 	m.typeRegistry.RegisterType(command)
-	m.commandDispatcher.RegisterCommandHandler(command, handler)
+	return m.commandDispatcher.RegisterCommandHandler(command, handler)
 }
 
-// RegisterGlobalHandler allows a caller to register a wildcard command handler call on any command received
-func (m *CommandDispatchManager) RegisterGlobalHandler(handler CommandHandler) {
-	m.commandDispatcher.RegisterGlobalHandler(handler)
+// RegisterGlobalHandler allows a caller to register a wildcard command handler call on any command received.
+// The returned subscription removes the handler again
+func (m *CommandDispatchManager) RegisterGlobalHandler(handler CommandHandler) Subscription {
+	return m.commandDispatcher.RegisterGlobalHandler(handler)
 }
 
-// Listen starts a listen loop processing channels related to new incoming events, errors and stop listening requests
-func (m *CommandDispatchManager) Listen(stop <-chan bool, exclusive bool, listenerCount int) error {
+// Listen starts a listen loop processing channels related to new incoming commands, errors and stop listening requests.
+// Signalling stop or calling Shutdown on the returned listener stops receiving commands
+func (m *CommandDispatchManager) Listen(stop <-chan bool, exclusive bool, listenerCount int) (*Listener, error) {
 	// Create communication channels
 	//
 	// for closing the queue listener,
 	closeChannel := make(chan chan error)
 	// receiving errors from the listener thread (go routine)
 	errorChannel := make(chan error)
+	listener := newListener("CommandDispatchManager", closeChannel, errorChannel, listenerCount, m.observers)
 
 	// Command received channel receives a result with a channel to respond to, signifying successful processing of the message.
 	// This should eventually call a command handler. See cqrs.NewVersionedCommandDispatcher()
-	receiveCommandHandler := func(command Command) error {
+	dispatchCommand := ChainCommandMiddleware(m.dispatchCommand, m.middleware...)
+	receiveCommandHandler := func(command Command) (err error) {
 		PackageLogger().Debugf("CommandDispatchManager.DispatchCommand: %v", command.CorrelationID)
-		err := m.commandDispatcher.DispatchCommand(command)
+		listener.track(func() { err = dispatchCommand(command) })
 		if err != nil {
 			PackageLogger().Debugf("Error dispatching command: %v", err)
+			listener.reportError(&ReceiverError{ReceiverErrorHandler, command.CommandType, command.MessageID, err})
 		}
 
 		return err
@@ -182,25 +392,10 @@ func (m *CommandDispatchManager) Listen(stop <-chan bool, exclusive bool, listen
 	// Start receiving commands by passing these channels to the worker thread (go routine)
 	options := CommandReceiverOptions{m.typeRegistry, closeChannel, errorChannel, receiveCommandHandler, exclusive, listenerCount}
 	if err := m.receiver.ReceiveCommands(options); err != nil {
-		return err
+		return nil, err
 	}
-	go func() {
-		for {
-			// Wait on multiple channels using the select control flow.
-			select {
-			case <-stop:
-				PackageLogger().Debugf("CommandDispatchManager.Stopping")
-				closeSignal := make(chan error)
-				closeChannel <- closeSignal
-				PackageLogger().Debugf("CommandDispatchManager.Stopped")
-				<-closeSignal
-			// Receiving on this channel signifys an error has occured worker processor side
-			case err := <-errorChannel:
-				PackageLogger().Debugf("CommandDispatchManager.ErrorReceived: %s", err)
 
-			}
-		}
-	}()
+	go listener.run(stop)
 
-	return nil
+	return listener, nil
 }
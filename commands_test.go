@@ -1,6 +1,7 @@
 package cqrs_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/andrewwebber/cqrs"
@@ -27,3 +28,47 @@ func TestCommandDispatcher(t *testing.T) {
 		t.Fatal("Expected success")
 	}
 }
+
+func TestStrictCommandDispatcher(t *testing.T) {
+	dispatcher := cqrs.NewMapBasedCommandDispatcher()
+	dispatcher.SetStrict(true)
+	handler := func(command cqrs.Command) error { return nil }
+
+	if _, err := dispatcher.RegisterCommandHandler(SampleMessageCommand{}, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dispatcher.RegisterCommandHandler(SampleMessageCommand{}, handler); !errors.Is(err, cqrs.ErrCommandHandlerAlreadyRegistered) {
+		t.Fatal("Expected second command handler registration to fail but got", err)
+	}
+
+	err := dispatcher.DispatchCommand(cqrs.CreateCommand(SampleCommand{"Nobody handles me"}))
+	if !errors.Is(err, cqrs.ErrUnhandledCommand) || !cqrs.IsPermanent(err) {
+		t.Fatal("Expected command without handler to be reported as permanently unhandled but got", err)
+	}
+}
+
+func TestCommandHandlerUnsubscribe(t *testing.T) {
+	dispatcher := cqrs.NewMapBasedCommandDispatcher()
+	dispatcher.SetStrict(true)
+	calls := 0
+	subscription, err := dispatcher.RegisterCommandHandler(SampleMessageCommand{}, func(command cqrs.Command) error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscription.Unsubscribe()
+	subscription.Unsubscribe()
+
+	err = dispatcher.DispatchCommand(cqrs.CreateCommand(SampleMessageCommand{"Hello world"}))
+	if !errors.Is(err, cqrs.ErrUnhandledCommand) || calls != 0 {
+		t.Fatal("Expected unsubscribed command handler to be removed but got", err)
+	}
+
+	if _, err := dispatcher.RegisterCommandHandler(SampleMessageCommand{}, func(command cqrs.Command) error { return nil }); err != nil {
+		t.Fatal("Expected a new owner to register after unsubscribing but got", err)
+	}
+}
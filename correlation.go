@@ -1,6 +1,7 @@
 package cqrs
 
 import (
+	"errors"
 	"time"
 )
 
@@ -10,10 +11,17 @@ const CQRSErrorEventType = "cqrs.ErrorEvent"
 // ErrorEvent is a generic event raised within the CQRS framework
 type ErrorEvent struct {
 	Message string
+	Fields  []FieldError `json:",omitempty"`
 }
 
 // DeliverCQRSError will deliver a CQRS error
 func DeliverCQRSError(correlationID string, err error, repo EventSourcingRepository) {
+	errorEvent := ErrorEvent{Message: err.Error()}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		errorEvent.Fields = validationErr.Fields
+	}
+
 	err = repo.GetEventStreamRepository().SaveIntegrationEvent(VersionedEvent{
 		ID:            "ve:" + NewUUIDString(),
 		CorrelationID: correlationID,
@@ -22,7 +30,7 @@ func DeliverCQRSError(correlationID string, err error, repo EventSourcingReposit
 		EventType:     CQRSErrorEventType,
 		Created:       time.Now(),
 
-		Event: ErrorEvent{Message: err.Error()}})
+		Event: errorEvent})
 
 	if err != nil {
 		PackageLogger().Debugf("ERROR saving integration event: %v\n", err)
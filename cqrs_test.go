@@ -44,11 +44,11 @@ func TestScenario(t *testing.T) {
 	commandDispatcherStopChannel := make(chan bool)
 	eventDispatcherStopChannel := make(chan bool)
 	go func() {
-		err := commandDispatcher.Listen(commandDispatcherStopChannel, false, 1)
+		_, err := commandDispatcher.Listen(commandDispatcherStopChannel, false, 1)
 		require.NoError(t, err)
 	}()
 	go func() {
-		err := eventDispatcher.Listen(eventDispatcherStopChannel, false, 1)
+		_, err := eventDispatcher.Listen(eventDispatcherStopChannel, false, 1)
 		require.NoError(t, err)
 	}()
 
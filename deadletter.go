@@ -0,0 +1,113 @@
+package cqrs
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// ErrDeadLetterNotFound is returned when a dead letter cannot be found
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter records a command or event that could not be processed by its handlers
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Command  *Command        `json:"command,omitempty"`
+	Event    *VersionedEvent `json:"event,omitempty"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	Handler  string          `json:"handler"`
+	Created  time.Time       `json:"time"`
+	// Permanent records that redelivering the message cannot resolve the failure, see PermanentError
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// NewCommandDeadLetter is a helper for creating a dead letter for a command whose handler failed
+func NewCommandDeadLetter(command Command, err error) DeadLetter {
+	deadLetter := newDeadLetter(err)
+	deadLetter.Command = &command
+	return deadLetter
+}
+
+// NewEventDeadLetter is a helper for creating a dead letter for an event whose handler failed
+func NewEventDeadLetter(event VersionedEvent, err error) DeadLetter {
+	deadLetter := newDeadLetter(err)
+	deadLetter.Event = &event
+	return deadLetter
+}
+
+func newDeadLetter(err error) DeadLetter {
+	deadLetter := DeadLetter{ID: "dl:" + NewUUIDString(), Error: err.Error(), Attempts: 1, Permanent: IsPermanent(err), Created: time.Now().UTC()}
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		deadLetter.Handler = handlerErr.Handler
+	}
+
+	return deadLetter
+}
+
+// DeadLetterStore is responsible for keeping commands and events whose handlers failed so they are never silently lost
+type DeadLetterStore interface {
+	SaveDeadLetter(DeadLetter) error
+	GetDeadLetters() ([]DeadLetter, error)
+	GetDeadLetter(id string) (DeadLetter, error)
+	RemoveDeadLetter(id string) error
+	PurgeDeadLetters() error
+}
+
+// RequeueDeadLetter republishes a dead lettered command or event and removes it from the store.
+// A requeued event is published to every subscriber of the event publisher
+func RequeueDeadLetter(store DeadLetterStore, id string, commands CommandPublisher, events VersionedEventPublisher) error {
+	deadLetter, err := store.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case deadLetter.Command != nil:
+		if commands == nil {
+			return errors.New("no command publisher to requeue dead letter " + id)
+		}
+
+		err = commands.PublishCommands([]Command{*deadLetter.Command})
+	case deadLetter.Event != nil:
+		if events == nil {
+			return errors.New("no event publisher to requeue dead letter " + id)
+		}
+
+		err = events.PublishEvents([]VersionedEvent{*deadLetter.Event})
+	default:
+		return errors.New("dead letter " + id + " holds neither a command nor an event")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return store.RemoveDeadLetter(id)
+}
+
+// HandlerError is returned by the dispatchers when a registered handler fails, identifying the failed handler
+type HandlerError struct {
+	Handler string
+	Err     error
+}
+
+func (e *HandlerError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error returned by the handler
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// handlerName returns the name of the function implementing a handler
+func handlerName(handler interface{}) string {
+	if function := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); function != nil {
+		return function.Name()
+	}
+
+	return ""
+}
@@ -0,0 +1,157 @@
+package cqrs_test
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type FlakyReadModel struct {
+	failures int32
+	handled  chan cqrs.VersionedEvent
+}
+
+func (model *FlakyReadModel) HandleSampleMessageReceivedEvent(event cqrs.VersionedEvent) error {
+	if atomic.AddInt32(&model.failures, -1) >= 0 {
+		return errors.New("read model unavailable")
+	}
+
+	model.handled <- event
+	return nil
+}
+
+func TestInMemoryEventBusDeadLetters(t *testing.T) {
+	store := cqrs.NewInMemoryDeadLetterStore()
+	bus := cqrs.NewInMemoryEventBus()
+	bus.SetDeadLetterStore(store)
+
+	model := &FlakyReadModel{failures: 1, handled: make(chan cqrs.VersionedEvent, 1)}
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, model.HandleSampleMessageReceivedEvent)
+
+	stop := make(chan bool)
+	if _, err := manager.Listen(stop, false, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	event := cqrs.VersionedEvent{ID: "ve:" + cqrs.NewUUIDString(), Event: SampleMessageReceivedEvent{"Hello world"}}
+	if err := bus.PublishEvents([]cqrs.VersionedEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+
+	var deadLetters []cqrs.DeadLetter
+	for start := time.Now(); len(deadLetters) == 0 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		deadLetters, _ = store.GetDeadLetters()
+	}
+
+	if len(deadLetters) != 1 {
+		t.Fatal("Expected the failed event to be dead lettered")
+	}
+
+	deadLetter, err := store.GetDeadLetter(deadLetters[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deadLetter.Event.ID != event.ID || deadLetter.Error != "read model unavailable" || deadLetter.Attempts != 1 {
+		t.Fatal("Unexpected dead letter", deadLetter)
+	}
+
+	if !strings.Contains(deadLetter.Handler, "HandleSampleMessageReceivedEvent") {
+		t.Fatal("Expected the dead letter to name the failed handler but got", deadLetter.Handler)
+	}
+
+	if err := cqrs.RequeueDeadLetter(store, deadLetter.ID, nil, bus); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case handled := <-model.handled:
+		if handled.ID != event.ID {
+			t.Fatal("Expected the requeued event to be handled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Test timed out")
+	}
+
+	if _, err := store.GetDeadLetter(deadLetter.ID); err != cqrs.ErrDeadLetterNotFound {
+		t.Fatal("Expected requeued dead letter to be removed")
+	}
+
+	stop <- true
+}
+
+func TestInMemoryDeadLetterStorePurge(t *testing.T) {
+	store := cqrs.NewInMemoryDeadLetterStore()
+	command := cqrs.CreateCommand(SampleMessageCommand{"Hello world"})
+	if err := store.SaveDeadLetter(cqrs.NewCommandDeadLetter(command, errors.New("failed"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.PurgeDeadLetters(); err != nil {
+		t.Fatal(err)
+	}
+
+	if deadLetters, _ := store.GetDeadLetters(); len(deadLetters) != 0 {
+		t.Fatal("Expected all dead letters to be purged")
+	}
+}
+
+func TestInMemoryBusesDeadLetterByDefaultAfterMaxAttempts(t *testing.T) {
+	bus := cqrs.NewInMemoryEventBusWithOptions(cqrs.InMemoryBusOptions{MaxAttempts: 3})
+	model := &FlakyReadModel{failures: 5, handled: make(chan cqrs.VersionedEvent, 1)}
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, model.HandleSampleMessageReceivedEvent)
+
+	stop := make(chan bool)
+	if _, err := manager.Listen(stop, false, 1); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { stop <- true }()
+
+	event := cqrs.VersionedEvent{ID: "ve:" + cqrs.NewUUIDString(), Event: SampleMessageReceivedEvent{"Hello world"}}
+	if err := bus.PublishEvents([]cqrs.VersionedEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+
+	var deadLetters []cqrs.DeadLetter
+	for start := time.Now(); len(deadLetters) == 0 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		deadLetters, _ = bus.DeadLetterStore().GetDeadLetters()
+	}
+
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 3 || atomic.LoadInt32(&model.failures) != 2 {
+		t.Fatal("Expected the event to be dead lettered after three attempts but got", deadLetters)
+	}
+
+	// Permanent failures are dead lettered without being retried
+	commands := cqrs.NewInMemoryCommandBusWithOptions(cqrs.InMemoryBusOptions{MaxAttempts: 3})
+	var attempts int32
+	closeChannel := make(chan chan error)
+	if err := commands.ReceiveCommands(cqrs.CommandReceiverOptions{Close: closeChannel, ReceiveCommand: func(command cqrs.Command) error {
+		atomic.AddInt32(&attempts, 1)
+		return cqrs.NewPermanentError(errors.New("invalid command"))
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := commands.PublishCommands([]cqrs.Command{cqrs.CreateCommand(SampleMessageCommand{"Hello world"})}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetters = nil
+	for start := time.Now(); len(deadLetters) == 0 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		deadLetters, _ = commands.DeadLetterStore().GetDeadLetters()
+	}
+
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 1 || !deadLetters[0].Permanent || atomic.LoadInt32(&attempts) != 1 {
+		t.Fatal("Expected the permanently failed command to be dead lettered after one attempt but got", deadLetters)
+	}
+
+	closeResponse := make(chan error)
+	closeChannel <- closeResponse
+	<-closeResponse
+}
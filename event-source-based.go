@@ -2,6 +2,7 @@ package cqrs
 
 import (
 	"reflect"
+	"time"
 )
 
 // EventSourced providers an interface for event sourced aggregate types
@@ -11,7 +12,7 @@ type EventSourced interface {
 	Version() int
 	SetVersion(int)
 	Events() []interface{}
-	CallEventHandler(event interface{})
+	CallEventHandler(event interface{}) error
 	SetSource(interface{})
 	WantsToSaveSnapshot() bool
 	SuggestSaveSnapshot()
@@ -25,6 +26,7 @@ type EventSourceBased struct {
 	source        interface{}
 	handlersCache HandlersCache
 	saveSnapshot  bool
+	created       []time.Time
 }
 
 // NewEventSourceBased constructor
@@ -34,24 +36,41 @@ func NewEventSourceBased(source interface{}) EventSourceBased {
 
 // NewEventSourceBasedWithID constructor
 func NewEventSourceBasedWithID(source interface{}, id string) EventSourceBased {
-	return EventSourceBased{id, 0, []interface{}{}, source, createHandlersCache(source), false}
+	return EventSourceBased{id, 0, []interface{}{}, source, createHandlersCache(source), false, []time.Time{}}
 }
 
-// Update should be called to change the state of an aggregate type
-func (s *EventSourceBased) Update(versionedEvent interface{}) {
-	s.CallEventHandler(versionedEvent)
-	s.events = append(s.events, versionedEvent)
+// Update should be called to change the state of an aggregate type.
+// If the aggregate's event handler returns an error the event is not recorded and the error is returned.
+func (s *EventSourceBased) Update(event interface{}) error {
+	created := time.Now().UTC()
+	if err := s.callEventHandler(event, created); err != nil {
+		return err
+	}
+
+	s.events = append(s.events, event)
+	s.created = append(s.created, created)
+	return nil
 }
 
 // CallEventHandler routes an event to an aggregate's event handler
-func (s *EventSourceBased) CallEventHandler(event interface{}) {
+func (s *EventSourceBased) CallEventHandler(event interface{}) error {
+	return s.callEventHandler(event, time.Now().UTC())
+}
+
+func (s *EventSourceBased) callEventHandler(event interface{}, created time.Time) error {
 	eventType := reflect.TypeOf(event)
 
-	if handler, ok := s.handlersCache[eventType]; ok {
-		handler(s.source, event)
-	} else {
+	handler, ok := s.handlersCache[eventType]
+	if !ok {
 		panic("No handler found for event type " + eventType.String())
 	}
+
+	return handler(s.source, VersionedEvent{
+		SourceID:  s.id,
+		Version:   s.version + len(s.events) + 1,
+		EventType: eventType.String(),
+		Created:   created,
+		Event:     event})
 }
 
 // ID provider the aggregate's ID
@@ -84,6 +103,11 @@ func (s *EventSourceBased) Events() []interface{} {
 	return s.events
 }
 
+// EventsCreated returns the times the newly created events were recorded, in the order of Events
+func (s *EventSourceBased) EventsCreated() []time.Time {
+	return s.created
+}
+
 // WantsToSaveSnapshot returns whether the aggregate suggests to persist a snapshot upon the next save.
 func (s *EventSourceBased) WantsToSaveSnapshot() bool {
 	return s.saveSnapshot
@@ -0,0 +1,240 @@
+package cqrs_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type LedgerOpenedEvent struct {
+	Owner string
+}
+
+type LedgerEntryRecordedEvent struct {
+	Amount float64
+}
+
+type LedgerClosedEvent struct {
+	Reason string
+}
+
+type Ledger struct {
+	cqrs.EventSourceBased
+
+	Owner        string
+	Balance      float64
+	LastVersion  int
+	LastCreated  time.Time
+	ClosedReason string
+}
+
+func NewLedger(id string) *Ledger {
+	ledger := new(Ledger)
+	ledger.EventSourceBased = cqrs.NewEventSourceBasedWithID(ledger, id)
+	return ledger
+}
+
+func (ledger *Ledger) HandleLedgerOpenedEvent(event LedgerOpenedEvent) {
+	ledger.Owner = event.Owner
+}
+
+func (ledger *Ledger) HandleLedgerEntryRecordedEvent(event LedgerEntryRecordedEvent, versionedEvent cqrs.VersionedEvent) error {
+	if event.Amount == 0 {
+		return errors.New("empty ledger entry")
+	}
+
+	ledger.Balance += event.Amount
+	ledger.LastVersion = versionedEvent.Version
+	ledger.LastCreated = versionedEvent.Created
+	return nil
+}
+
+func (ledger *Ledger) HandleLedgerClosedEvent(event *LedgerClosedEvent) error {
+	ledger.ClosedReason = event.Reason
+	return nil
+}
+
+func (ledger *Ledger) CopyFrom(snapshot interface{}) {
+	source := snapshot.(*Ledger)
+	ledger.Owner = source.Owner
+	ledger.Balance = source.Balance
+	ledger.LastVersion = source.LastVersion
+}
+
+func TestEventSourceBasedHandlerSignatures(t *testing.T) {
+	ledger := NewLedger(cqrs.NewUUIDString())
+	if err := ledger.Update(LedgerOpenedEvent{"John Snow"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ledger.Update(LedgerEntryRecordedEvent{10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ledger.LastVersion != 2 {
+		t.Fatal("Expected the versioned event envelope to carry version 2 but got", ledger.LastVersion)
+	}
+
+	if err := ledger.Update(LedgerEntryRecordedEvent{0}); err == nil {
+		t.Fatal("Expected the aggregate to reject an empty ledger entry")
+	}
+
+	if len(ledger.Events()) != 2 {
+		t.Fatal("Expected rejected events not to be recorded")
+	}
+
+	if err := ledger.Update(LedgerClosedEvent{"Audit"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ledger.ClosedReason != "Audit" {
+		t.Fatal("Expected pointer receiving handler to be called")
+	}
+}
+
+func TestEventSourcingRepositoryPersistsEnvelopeCreatedTime(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	persistance := cqrs.NewInMemoryEventStreamRepository()
+	repository := cqrs.NewRepository(persistance, typeRegistry)
+
+	ledger := NewLedger(cqrs.NewUUIDString())
+	if err := ledger.Update(LedgerEntryRecordedEvent{10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.Save(ledger, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := persistance.Get(ledger.ID(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || !events[0].Created.Equal(ledger.LastCreated) {
+		t.Fatal("Expected the persisted event to be created when the handler saw it but got", events, ledger.LastCreated)
+	}
+}
+
+func TestEventSourcingRepositoryPropagatesHandlerErrors(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	persistance := cqrs.NewInMemoryEventStreamRepository()
+	repository := cqrs.NewRepository(persistance, typeRegistry)
+
+	id := cqrs.NewUUIDString()
+	err := persistance.Save(id, []cqrs.VersionedEvent{
+		{ID: "ve:1", SourceID: id, Version: 1, EventType: "cqrs_test.LedgerOpenedEvent", Event: LedgerOpenedEvent{"John Snow"}},
+		{ID: "ve:2", SourceID: id, Version: 2, EventType: "cqrs_test.LedgerEntryRecordedEvent", Event: LedgerEntryRecordedEvent{0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repository.Get(id, NewLedger(id)); err == nil {
+		t.Fatal("Expected invalid historical data to be rejected")
+	}
+}
+
+func saveLedgerHistory(t *testing.T, persistance cqrs.EventStreamRepository, id string, created time.Time) {
+	err := persistance.Save(id, []cqrs.VersionedEvent{
+		{ID: "ve:1", SourceID: id, Version: 1, Created: created, EventType: "cqrs_test.LedgerOpenedEvent", Event: LedgerOpenedEvent{"John Snow"}},
+		{ID: "ve:2", SourceID: id, Version: 2, Created: created.Add(time.Hour), EventType: "cqrs_test.LedgerEntryRecordedEvent", Event: LedgerEntryRecordedEvent{10}},
+		{ID: "ve:3", SourceID: id, Version: 3, Created: created.Add(2 * time.Hour), EventType: "cqrs_test.LedgerEntryRecordedEvent", Event: LedgerEntryRecordedEvent{20}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventSourcingRepositoryTemporalQueries(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	persistance := cqrs.NewInMemoryEventStreamRepository()
+	repository := cqrs.NewRepository(persistance, typeRegistry).(cqrs.TemporalRepository)
+
+	id := cqrs.NewUUIDString()
+	created := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	saveLedgerHistory(t, persistance, id, created)
+
+	ledger := NewLedger(id)
+	if err := repository.GetAt(id, ledger, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if ledger.Balance != 10 || ledger.Version() != 2 {
+		t.Fatal("Expected the ledger at version 2 but got", ledger.Balance, ledger.Version())
+	}
+
+	ledger = NewLedger(id)
+	if err := repository.GetAsOf(id, ledger, created.Add(90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if ledger.Balance != 10 || ledger.Version() != 2 {
+		t.Fatal("Expected the ledger as of version 2 but got", ledger.Balance, ledger.Version())
+	}
+
+	// A ledger already loaded at the requested state is left as is, while one loaded past it cannot go back
+	if err := repository.GetAt(id, ledger, 2); err != nil || ledger.Balance != 10 {
+		t.Fatal("Expected the loaded version to be kept but got", err, ledger.Balance)
+	}
+
+	if err := repository.GetAsOf(id, ledger, created.Add(100*time.Minute)); err != nil || ledger.Version() != 2 {
+		t.Fatal("Expected the state loaded as of the time to be kept but got", err, ledger.Version())
+	}
+
+	if err := repository.GetAsOf(id, ledger, created.Add(3*time.Hour)); err != nil || ledger.Balance != 30 || ledger.Version() != 3 {
+		t.Fatal("Expected only the missing events to be applied but got", err, ledger.Balance, ledger.Version())
+	}
+
+	if err := repository.GetAt(id, ledger, 2); !errors.Is(err, cqrs.ErrAggregateAlreadyPast) {
+		t.Fatal("Expected an earlier version to be rejected but got", err)
+	}
+
+	if err := repository.GetAsOf(id, ledger, created.Add(90*time.Minute)); !errors.Is(err, cqrs.ErrAggregateAlreadyPast) {
+		t.Fatal("Expected an earlier time to be rejected but got", err)
+	}
+
+	if err := repository.GetAt(id, NewLedger(id), 4); !errors.Is(err, cqrs.ErrAggregateVersionNotFound) {
+		t.Fatal("Expected an unknown version to be rejected but got", err)
+	}
+
+	if err := repository.GetAsOf(id, NewLedger(id), created.Add(-time.Minute)); !errors.Is(err, cqrs.ErrAggregateNotCreatedYet) {
+		t.Fatal("Expected a time before the first event to be rejected but got", err)
+	}
+}
+
+func TestEventSourcingRepositoryTemporalQueriesUseSafeSnapshots(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	persistance := cqrs.NewInMemoryEventStreamRepository()
+	repository := cqrs.NewRepository(persistance, typeRegistry).(cqrs.TemporalRepository)
+
+	id := cqrs.NewUUIDString()
+	saveLedgerHistory(t, persistance, id, time.Now().UTC())
+
+	// The snapshot balance differs from the history to reveal whether the snapshot was used
+	snapshot := NewLedger(id)
+	snapshot.Owner = "John Snow"
+	snapshot.Balance = 100
+	snapshot.SetVersion(2)
+	if err := persistance.SaveSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	ledger := NewLedger(id)
+	if err := repository.GetAt(id, ledger, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if ledger.Balance != 120 || ledger.Version() != 3 {
+		t.Fatal("Expected the snapshot to be used but got", ledger.Balance, ledger.Version())
+	}
+
+	ledger = NewLedger(id)
+	if err := repository.GetAt(id, ledger, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if ledger.Balance != 0 || ledger.Owner != "John Snow" || ledger.Version() != 1 {
+		t.Fatal("Expected a snapshot taken after the requested version to be ignored but got", ledger.Balance, ledger.Version())
+	}
+}
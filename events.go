@@ -1,8 +1,12 @@
 package cqrs
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -23,6 +27,8 @@ type VersionedEvent struct {
 	EventType     string    `json:"eventType"`
 	Created       time.Time `json:"time"`
 	Event         interface{}
+	// Replayed marks events redelivered from history by ReplayEvents so handlers can suppress side effects
+	Replayed bool `json:"-"`
 }
 
 // ByCreated is an alias for sorting VersionedEvents by the create field
@@ -49,6 +55,12 @@ type VersionedEventReceiver interface {
 	ReceiveEvents(VersionedEventReceiverOptions) error
 }
 
+// VersionedEventTypeBinder is implemented by event receivers that only subscribe to the event types handled by the dispatcher.
+// While listening, the dispatch manager passes it the handled event types whenever handlers are registered or unsubscribed
+type VersionedEventTypeBinder interface {
+	BindEventTypes(eventTypes []string) error
+}
+
 // EventBus ...
 type EventBus interface {
 	VersionedEventPublisher
@@ -60,6 +72,9 @@ type VersionedEventDispatchManager struct {
 	versionedEventDispatcher *MapBasedVersionedEventDispatcher
 	typeRegistry             TypeRegistry
 	receiver                 VersionedEventReceiver
+	middleware               []VersionedEventMiddleware
+	observers                []ReceiverErrorObserver
+	checkpointer             *eventCheckpointer
 }
 
 // VersionedEventDispatcher the internal versioned event dispatcher
@@ -82,19 +97,111 @@ type VersionedEventReceiverOptions struct {
 	ReceiveEvent  VersionedEventHandler
 	Exclusive     bool
 	ListenerCount int
+	// EventTypes lists the types of the events handled by the dispatcher, allowing receivers to only subscribe to those events.
+	// It is nil when every event is handled
+	EventTypes []string
 }
 
 // VersionedEventDispatcher is responsible for routing events from the event manager to call handlers responsible for processing received events
 type VersionedEventDispatcher interface {
 	DispatchEvent(VersionedEvent) error
-	RegisterEventHandler(event interface{}, handler VersionedEventHandler)
-	RegisterGlobalHandler(handler VersionedEventHandler)
+	RegisterEventHandler(event interface{}, handler VersionedEventHandler) Subscription
+	RegisterGlobalHandler(handler VersionedEventHandler) Subscription
 }
 
+// DispatchMode controls how the MapBasedVersionedEventDispatcher runs the handlers registered for an event
+type DispatchMode int
+
+const (
+	// DispatchSequential runs handlers one after another and stops at the first failing handler. This is the default
+	DispatchSequential DispatchMode = iota
+	// DispatchIndependent runs handlers one after another, running every handler regardless of earlier failures
+	DispatchIndependent
+	// DispatchParallel runs handlers concurrently. Handlers must be safe to run in parallel with each other
+	DispatchParallel
+)
+
+// DispatchError is returned by the MapBasedVersionedEventDispatcher when handlers fail in the independent or parallel dispatch modes
+type DispatchError struct {
+	EventID   string
+	EventType string
+	Errors    []*HandlerError
+}
+
+func (e *DispatchError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("handler %s failed for %s: %v", e.Errors[0].Handler, e.EventType, e.Errors[0].Err)
+	}
+
+	return fmt.Sprintf("%d handlers failed for %s, first error: %v", len(e.Errors), e.EventType, e.Errors[0].Err)
+}
+
+// Is reports whether the error of any failed handler matches target, so errors.Is sees through the dispatch error
+func (e *DispatchError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first error of a failed handler matching target, so errors.As sees through the dispatch error.
+// The dispatch error is only a PermanentError when every failed handler failed permanently, as redelivering the event
+// retries the handlers that failed transiently
+func (e *DispatchError) As(target interface{}) bool {
+	if _, ok := target.(**PermanentError); ok && !e.permanent() {
+		return false
+	}
+
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permanent reports whether every failed handler failed permanently
+func (e *DispatchError) permanent() bool {
+	for _, err := range e.Errors {
+		if !IsPermanent(err) {
+			return false
+		}
+	}
+
+	return len(e.Errors) > 0
+}
+
+// DefaultDispatchMemory is the number of events whose succeeded handlers are remembered for redeliveries in the independent and
+// parallel dispatch modes. The oldest events are forgotten first, so a redelivery of a forgotten event runs every handler again
+var DefaultDispatchMemory = 10000
+
 // MapBasedVersionedEventDispatcher is a simple implementation of the versioned event dispatcher. Using a map it registered event handlers to event types
 type MapBasedVersionedEventDispatcher struct {
-	registry       map[reflect.Type][]VersionedEventHandler
-	globalHandlers []VersionedEventHandler
+	lock           sync.RWMutex
+	registry       map[reflect.Type][]versionedEventHandlerEntry
+	globalHandlers []versionedEventHandlerEntry
+	nextHandlerID  int
+	mode           DispatchMode
+	succeeded      map[string]*list.Element
+	succeededOrder *list.List
+	succeededLock  sync.Mutex
+	typesLock      sync.Mutex
+	typesChanged   func([]string)
+}
+
+type versionedEventHandlerEntry struct {
+	id      int
+	handler VersionedEventHandler
+}
+
+// succeededEvent records the handlers that succeeded for an event that failed in other handlers
+type succeededEvent struct {
+	eventID  string
+	handlers map[int]bool
 }
 
 // VersionedEventHandler is a function that takes a versioned event
@@ -102,109 +209,377 @@ type VersionedEventHandler func(VersionedEvent) error
 
 // NewVersionedEventDispatcher is a constructor for the MapBasedVersionedEventDispatcher
 func NewVersionedEventDispatcher() *MapBasedVersionedEventDispatcher {
-	registry := make(map[reflect.Type][]VersionedEventHandler)
-	return &MapBasedVersionedEventDispatcher{registry, []VersionedEventHandler{}}
+	return &MapBasedVersionedEventDispatcher{
+		registry:       make(map[reflect.Type][]versionedEventHandlerEntry),
+		succeeded:      make(map[string]*list.Element),
+		succeededOrder: list.New()}
 }
 
-// RegisterEventHandler allows a caller to register an event handler given an event of the specified type being received
-func (m *MapBasedVersionedEventDispatcher) RegisterEventHandler(event interface{}, handler VersionedEventHandler) {
+// SetDispatchMode sets how handlers are run for each dispatched event.
+// In the independent and parallel modes every handler runs even when others fail, failures are returned as a *DispatchError and
+// handlers that succeeded are remembered by event ID so a redelivered event only runs the handlers that failed.
+// Events are forgotten once every handler succeeded or after DefaultDispatchMemory newer events. The dispatch error is permanent only
+// when every failed handler failed permanently, and panics in the parallel mode fail the panicking handler.
+// The mode must be set before dispatching events
+func (m *MapBasedVersionedEventDispatcher) SetDispatchMode(mode DispatchMode) {
+	m.mode = mode
+}
+
+// RegisterEventHandler allows a caller to register an event handler given an event of the specified type being received.
+// The returned subscription removes the handler again
+func (m *MapBasedVersionedEventDispatcher) RegisterEventHandler(event interface{}, handler VersionedEventHandler) Subscription {
 	eventType := reflect.TypeOf(event)
-	handlers, ok := m.registry[eventType]
-	if ok {
-		m.registry[eventType] = append(handlers, handler)
-	} else {
-		m.registry[eventType] = []VersionedEventHandler{handler}
+
+	m.lock.Lock()
+	entry := m.newHandlerEntry(handler)
+	m.registry[eventType] = append(m.registry[eventType], entry)
+	m.lock.Unlock()
+	m.notifyHandledEventTypes()
+
+	return newSubscription(func() {
+		m.lock.Lock()
+		if handlers := removeVersionedEventHandler(m.registry[eventType], entry.id); len(handlers) > 0 {
+			m.registry[eventType] = handlers
+		} else {
+			delete(m.registry, eventType)
+		}
+		m.lock.Unlock()
+		m.notifyHandledEventTypes()
+	})
+}
+
+// RegisterGlobalHandler allows a caller to register a wildcard event handler call on any event received.
+// The returned subscription removes the handler again
+func (m *MapBasedVersionedEventDispatcher) RegisterGlobalHandler(handler VersionedEventHandler) Subscription {
+	m.lock.Lock()
+	entry := m.newHandlerEntry(handler)
+	m.globalHandlers = append(m.globalHandlers, entry)
+	m.lock.Unlock()
+	m.notifyHandledEventTypes()
+
+	return newSubscription(func() {
+		m.lock.Lock()
+		m.globalHandlers = removeVersionedEventHandler(m.globalHandlers, entry.id)
+		m.lock.Unlock()
+		m.notifyHandledEventTypes()
+	})
+}
+
+// handledEventTypes returns the sorted names of the event types with registered handlers, or nil when a global handler handles every event
+func (m *MapBasedVersionedEventDispatcher) handledEventTypes() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if len(m.globalHandlers) > 0 {
+		return nil
+	}
+
+	eventTypes := make([]string, 0, len(m.registry))
+	for eventType := range m.registry {
+		eventTypes = append(eventTypes, eventType.String())
 	}
+
+	sort.Strings(eventTypes)
+
+	return eventTypes
+}
+
+// onHandledEventTypesChanged sets a function called with the handled event types whenever handlers are registered or unsubscribed
+func (m *MapBasedVersionedEventDispatcher) onHandledEventTypesChanged(changed func([]string)) {
+	m.typesLock.Lock()
+	defer m.typesLock.Unlock()
+	m.typesChanged = changed
 }
 
-// RegisterGlobalHandler allows a caller to register a wildcard event handler call on any event received
-func (m *MapBasedVersionedEventDispatcher) RegisterGlobalHandler(handler VersionedEventHandler) {
-	m.globalHandlers = append(m.globalHandlers, handler)
+// notifyHandledEventTypes passes the handled event types to the change function. Notifications are serialized so the last one
+// always carries the current event types
+func (m *MapBasedVersionedEventDispatcher) notifyHandledEventTypes() {
+	m.typesLock.Lock()
+	defer m.typesLock.Unlock()
+	if m.typesChanged != nil {
+		m.typesChanged(m.handledEventTypes())
+	}
+}
+
+func (m *MapBasedVersionedEventDispatcher) newHandlerEntry(handler VersionedEventHandler) versionedEventHandlerEntry {
+	m.nextHandlerID++
+	return versionedEventHandlerEntry{m.nextHandlerID, handler}
+}
+
+// removeVersionedEventHandler returns a copy of the handlers without the handler with the given id.
+// Handlers are copied so dispatches already running keep a consistent list
+func removeVersionedEventHandler(handlers []versionedEventHandlerEntry, id int) []versionedEventHandlerEntry {
+	remaining := make([]versionedEventHandlerEntry, 0, len(handlers))
+	for _, entry := range handlers {
+		if entry.id != id {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	return remaining
 }
 
 // DispatchEvent executes all event handlers registered for the given event type
 func (m *MapBasedVersionedEventDispatcher) DispatchEvent(event VersionedEvent) error {
-	eventType := reflect.TypeOf(event.Event)
-	if handlers, ok := m.registry[eventType]; ok {
-		for _, handler := range handlers {
-			if err := handler(event); err != nil {
+	m.lock.RLock()
+	handlers := append(append([]versionedEventHandlerEntry{}, m.registry[reflect.TypeOf(event.Event)]...), m.globalHandlers...)
+	m.lock.RUnlock()
+
+	if m.mode == DispatchSequential {
+		for _, entry := range handlers {
+			if err := entry.handler(event); err != nil {
 				metricsEventsFailed.WithLabelValues(event.EventType).Inc()
-				return err
+				return &HandlerError{handlerName(entry.handler), err}
 			}
 		}
+
+		metricsEventsDispatched.WithLabelValues(event.EventType).Inc()
+
+		return nil
 	}
 
-	for _, handler := range m.globalHandlers {
-		if err := handler(event); err != nil {
-			metricsEventsFailed.WithLabelValues(event.EventType).Inc()
-			return err
+	pending := m.pendingHandlers(event.ID, handlers)
+	results := make([]*HandlerError, len(pending))
+	if m.mode == DispatchParallel {
+		var wg sync.WaitGroup
+		for i, entry := range pending {
+			wg.Add(1)
+			go func(i int, entry versionedEventHandlerEntry) {
+				defer wg.Done()
+				// A panic cannot be recovered by middleware on the dispatching go routine, so it fails the handler instead
+				defer func() {
+					if r := recover(); r != nil {
+						PackageLogger().Debugf("Recovered from panic handling event %s: %v", event.EventType, r)
+						results[i] = &HandlerError{handlerName(entry.handler), fmt.Errorf("panic handling event %s: %v", event.EventType, r)}
+					}
+				}()
+
+				results[i] = m.runHandler(event, entry)
+			}(i, entry)
+		}
+
+		wg.Wait()
+	} else {
+		for i, entry := range pending {
+			results[i] = m.runHandler(event, entry)
 		}
 	}
 
+	var failed []*HandlerError
+	for _, result := range results {
+		if result != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	if len(failed) > 0 {
+		// The succeeded handlers stay remembered, even for permanent failures, so requeueing a dead lettered event only runs the failed handlers
+		metricsEventsFailed.WithLabelValues(event.EventType).Inc()
+		return &DispatchError{event.ID, event.EventType, failed}
+	}
+
+	m.forgetSucceeded(event.ID)
 	metricsEventsDispatched.WithLabelValues(event.EventType).Inc()
 
 	return nil
 }
 
+// runHandler runs a single handler, remembering it succeeded for the event
+func (m *MapBasedVersionedEventDispatcher) runHandler(event VersionedEvent, entry versionedEventHandlerEntry) *HandlerError {
+	if err := entry.handler(event); err != nil {
+		return &HandlerError{handlerName(entry.handler), err}
+	}
+
+	if event.ID != "" {
+		m.succeededLock.Lock()
+		element, ok := m.succeeded[event.ID]
+		if !ok {
+			element = m.succeededOrder.PushBack(&succeededEvent{event.ID, make(map[int]bool)})
+			m.succeeded[event.ID] = element
+			for m.succeededOrder.Len() > DefaultDispatchMemory {
+				oldest := m.succeededOrder.Remove(m.succeededOrder.Front()).(*succeededEvent)
+				delete(m.succeeded, oldest.eventID)
+			}
+		}
+
+		element.Value.(*succeededEvent).handlers[entry.id] = true
+		m.succeededLock.Unlock()
+	}
+
+	return nil
+}
+
+// pendingHandlers filters out the handlers that already succeeded for a previous delivery of the event
+func (m *MapBasedVersionedEventDispatcher) pendingHandlers(eventID string, handlers []versionedEventHandlerEntry) []versionedEventHandlerEntry {
+	m.succeededLock.Lock()
+	defer m.succeededLock.Unlock()
+
+	element, ok := m.succeeded[eventID]
+	if eventID == "" || !ok {
+		return handlers
+	}
+
+	succeeded := element.Value.(*succeededEvent).handlers
+	pending := make([]versionedEventHandlerEntry, 0, len(handlers))
+	for _, entry := range handlers {
+		if !succeeded[entry.id] {
+			pending = append(pending, entry)
+		}
+	}
+
+	return pending
+}
+
+func (m *MapBasedVersionedEventDispatcher) forgetSucceeded(eventID string) {
+	m.succeededLock.Lock()
+	defer m.succeededLock.Unlock()
+
+	if element, ok := m.succeeded[eventID]; ok {
+		m.succeededOrder.Remove(element)
+		delete(m.succeeded, eventID)
+	}
+}
+
 // NewVersionedEventDispatchManager is a constructor for the VersionedEventDispatchManager
 func NewVersionedEventDispatchManager(receiver VersionedEventReceiver, registry TypeRegistry) *VersionedEventDispatchManager {
-	return &VersionedEventDispatchManager{NewVersionedEventDispatcher(), registry, receiver}
+	return &VersionedEventDispatchManager{versionedEventDispatcher: NewVersionedEventDispatcher(), typeRegistry: registry, receiver: receiver}
 }
 
-// RegisterEventHandler allows a caller to register an event handler given an event of the specified type being received
-func (m *VersionedEventDispatchManager) RegisterEventHandler(event interface{}, handler VersionedEventHandler) {
+// Use appends middleware wrapping the dispatch of every received event. The first middleware registered is the outermost.
+// Middleware must be registered before calling Listen
+func (m *VersionedEventDispatchManager) Use(middleware ...VersionedEventMiddleware) {
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// OnError registers an observer called with every error reported while receiving and dispatching events, including
+// deserialization failures, handler failures and lost connections.
+// Observers must be registered before calling Listen
+func (m *VersionedEventDispatchManager) OnError(observer ReceiverErrorObserver) {
+	m.observers = append(m.observers, observer)
+}
+
+// SetDispatchMode sets how the handlers registered for an event are run. See MapBasedVersionedEventDispatcher.SetDispatchMode
+func (m *VersionedEventDispatchManager) SetDispatchMode(mode DispatchMode) {
+	m.versionedEventDispatcher.SetDispatchMode(mode)
+}
+
+// RegisterEventHandler allows a caller to register an event handler given an event of the specified type being received.
+// The returned subscription removes the handler again
+func (m *VersionedEventDispatchManager) RegisterEventHandler(event interface{}, handler VersionedEventHandler) Subscription {
 	m.typeRegistry.RegisterType(event)
-	m.versionedEventDispatcher.RegisterEventHandler(event, handler)
+	return m.versionedEventDispatcher.RegisterEventHandler(event, handler)
+}
+
+// RegisterGlobalHandler allows a caller to register a wildcard event handler call on any event received.
+// The returned subscription removes the handler again
+func (m *VersionedEventDispatchManager) RegisterGlobalHandler(handler VersionedEventHandler) Subscription {
+	return m.versionedEventDispatcher.RegisterGlobalHandler(handler)
 }
 
-// RegisterGlobalHandler allows a caller to register a wildcard event handler call on any event received
-func (m *VersionedEventDispatchManager) RegisterGlobalHandler(handler VersionedEventHandler) {
-	m.versionedEventDispatcher.RegisterGlobalHandler(handler)
+// SetCheckpointing makes the manager a resumable subscriber with the given name. The checkpoint of the dispatched events
+// is committed to the store every batchSize successfully dispatched events and when the listener shuts down.
+// Checkpoints count events in the order of the integration event log, so events should be received by a single listener.
+// The checkpoint never passes an event whose handlers failed until it is redelivered and dispatched successfully, so checkpointing
+// needs a receiver redelivering failed events. ErrCheckpointStuck is reported to the OnError observers when a failed event is not redelivered
+func (m *VersionedEventDispatchManager) SetCheckpointing(name string, store CheckpointStore, batchSize int) {
+	m.checkpointer = newEventCheckpointer(name, store, batchSize)
 }
 
-// Listen starts a listen loop processing channels related to new incoming events, errors and stop listening requests
-func (m *VersionedEventDispatchManager) Listen(stop <-chan bool, exclusive bool, listenerCount int) error {
+// CatchUp dispatches the events stored in the log since the subscriber's checkpoint, resuming after a restart without replaying
+// from the start of the log. Call it before Listen. Live events already dispatched while catching up are skipped once listening
+func (m *VersionedEventDispatchManager) CatchUp(log VersionedEventPublicationLogger) error {
+	if m.checkpointer == nil {
+		return ErrCheckpointingNotConfigured
+	}
+
+	if _, err := m.checkpointer.load(); err != nil {
+		return err
+	}
+
+	events, err := log.AllIntegrationEventsEverPublished()
+	if err != nil {
+		return err
+	}
+
+	dispatchEvent := ChainVersionedEventMiddleware(m.versionedEventDispatcher.DispatchEvent, m.middleware...)
+	if err := m.checkpointer.catchUp(events, dispatchEvent); err != nil {
+		if errCommit := m.checkpointer.commit(); errCommit != nil {
+			PackageLogger().Debugf("Error committing checkpoint: %v", errCommit)
+		}
+
+		return err
+	}
+
+	return m.checkpointer.commit()
+}
+
+// Listen starts a listen loop processing channels related to new incoming events, errors and stop listening requests.
+// Signalling stop or calling Shutdown on the returned listener stops receiving events
+func (m *VersionedEventDispatchManager) Listen(stop <-chan bool, exclusive bool, listenerCount int) (*Listener, error) {
 	// Create communication channels
 	//
 	// for closing the queue listener,
 	closeChannel := make(chan chan error)
 	// receiving errors from the listener thread (go routine)
 	errorChannel := make(chan error)
+	listener := newListener("EventDispatchManager", closeChannel, errorChannel, listenerCount, m.observers)
+	if m.checkpointer != nil {
+		listener.flush = m.checkpointer.commit
+		m.checkpointer.reportStuck(func(err error) { listener.reportError(NewReceiverError(ReceiverErrorCheckpoint, "", err)) })
+	}
 
 	// Version event received channel receives a result with a channel to respond to, signifying successful processing of the message.
 	// This should eventually call an event handler. See cqrs.NewVersionedEventDispatcher()
-	versionedEventHandler := func(event VersionedEvent) error {
-		err := m.versionedEventDispatcher.DispatchEvent(event)
+	dispatchEvent := ChainVersionedEventMiddleware(m.versionedEventDispatcher.DispatchEvent, m.middleware...)
+	versionedEventHandler := func(event VersionedEvent) (err error) {
+		if m.checkpointer != nil && m.checkpointer.handledWhileCatchingUp(event) {
+			return nil
+		}
+
+		var pending *pendingCheckpoint
+		if m.checkpointer != nil {
+			pending = m.checkpointer.received(event)
+		}
+
+		listener.track(func() { err = dispatchEvent(event) })
 		if err != nil {
 			PackageLogger().Debugf("Error dispatching event: %v", err)
+			listener.reportError(&ReceiverError{ReceiverErrorHandler, event.EventType, event.ID, err})
+			return err
 		}
 
-		return err
+		if pending != nil {
+			if errCheckpoint := m.checkpointer.handled(pending); errCheckpoint != nil {
+				PackageLogger().Debugf("Error committing checkpoint: %v", errCheckpoint)
+			}
+		}
+
+		return nil
 	}
 
 	// Start receiving events by passing these channels to the worker thread (go routine)
-	options := VersionedEventReceiverOptions{m.typeRegistry, closeChannel, errorChannel, versionedEventHandler, exclusive, listenerCount}
+	options := VersionedEventReceiverOptions{m.typeRegistry, closeChannel, errorChannel, versionedEventHandler, exclusive, listenerCount, m.versionedEventDispatcher.handledEventTypes()}
 	if err := m.receiver.ReceiveEvents(options); err != nil {
-		return err
+		return nil, err
 	}
 
-	go func() {
-		for {
-			// Wait on multiple channels using the select control flow.
+	// Follow handlers registered or unsubscribed while listening
+	if binder, ok := m.receiver.(VersionedEventTypeBinder); ok {
+		m.versionedEventDispatcher.onHandledEventTypesChanged(func(eventTypes []string) {
 			select {
-			//PackageLogger().Debugf(nil, "EventDispatchManager.DispatchSuccessful")
-			case <-stop:
-				PackageLogger().Debugf("EventDispatchManager.Stopping")
-				closeSignal := make(chan error)
-				closeChannel <- closeSignal
-				PackageLogger().Debugf("EventDispatchManager.Stopped")
-				<-closeSignal
-			// Receiving on this channel signifys an error has occured worker processor side
-			case err := <-errorChannel:
-				PackageLogger().Debugf("EventDispatchManager.ErrorReceived: %v", err)
+			case <-listener.done:
+				return
+			default:
 			}
-		}
-	}()
 
-	return nil
+			if err := binder.BindEventTypes(eventTypes); err != nil {
+				PackageLogger().Debugf("Error binding event types: %v", err)
+				listener.reportError(NewReceiverError(ReceiverErrorConnection, "", err))
+			}
+		})
+	}
+
+	go listener.run(stop)
+
+	return listener, nil
 }
@@ -1,6 +1,10 @@
 package cqrs_test
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/andrewwebber/cqrs"
@@ -27,3 +31,215 @@ func TestDefaultVersionedEventDispatcher(t *testing.T) {
 		t.Fatal("Expected success")
 	}
 }
+
+func TestIndependentVersionedEventDispatcher(t *testing.T) {
+	for _, mode := range []cqrs.DispatchMode{cqrs.DispatchIndependent, cqrs.DispatchParallel} {
+		dispatcher := cqrs.NewVersionedEventDispatcher()
+		dispatcher.SetDispatchMode(mode)
+
+		var lock sync.Mutex
+		calls := map[string]int{}
+		failing := true
+		handler := func(name string) cqrs.VersionedEventHandler {
+			return func(event cqrs.VersionedEvent) error {
+				lock.Lock()
+				defer lock.Unlock()
+				calls[name]++
+				if name == "failing" && failing {
+					return errors.New("read model unavailable")
+				}
+
+				return nil
+			}
+		}
+
+		dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, handler("first"))
+		dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, handler("failing"))
+		dispatcher.RegisterGlobalHandler(handler("global"))
+
+		event := cqrs.VersionedEvent{ID: "event-1", EventType: "SampleMessageReceivedEvent", Event: SampleMessageReceivedEvent{"Hello world"}}
+		err := dispatcher.DispatchEvent(event)
+		var dispatchErr *cqrs.DispatchError
+		if !errors.As(err, &dispatchErr) {
+			t.Fatalf("mode %d: expected a dispatch error but got %v", mode, err)
+		}
+
+		if len(dispatchErr.Errors) != 1 {
+			t.Fatalf("mode %d: expected one failed handler but got %d", mode, len(dispatchErr.Errors))
+		}
+
+		if calls["first"] != 1 || calls["global"] != 1 {
+			t.Fatalf("mode %d: expected every handler to run despite the failure: %v", mode, calls)
+		}
+
+		failing = false
+		if err := dispatcher.DispatchEvent(event); err != nil {
+			t.Fatal(err)
+		}
+
+		if calls["first"] != 1 || calls["global"] != 1 || calls["failing"] != 2 {
+			t.Fatalf("mode %d: expected only the failed handler to be retried: %v", mode, calls)
+		}
+	}
+}
+
+func TestVersionedEventHandlerUnsubscribe(t *testing.T) {
+	dispatcher := cqrs.NewVersionedEventDispatcher()
+	calls := 0
+	var subscription cqrs.Subscription
+	subscription = dispatcher.RegisterGlobalHandler(func(event cqrs.VersionedEvent) error {
+		calls++
+		subscription.Unsubscribe()
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := dispatcher.DispatchEvent(cqrs.VersionedEvent{Event: SampleMessageReceivedEvent{"Hello world"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("Expected a handler unsubscribing itself to be called once but was called %d times", calls)
+	}
+}
+
+// bindingEventBus records the event types passed to it while listening
+type bindingEventBus struct {
+	*cqrs.InMemoryEventBus
+	lock     sync.Mutex
+	bindings [][]string
+}
+
+func (b *bindingEventBus) BindEventTypes(eventTypes []string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.bindings = append(b.bindings, eventTypes)
+	return nil
+}
+
+func (b *bindingEventBus) lastBinding() ([]string, int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.bindings) == 0 {
+		return nil, 0
+	}
+
+	return b.bindings[len(b.bindings)-1], len(b.bindings)
+}
+
+func TestVersionedEventDispatchManagerBindsHandledEventTypes(t *testing.T) {
+	bus := &bindingEventBus{InMemoryEventBus: cqrs.NewInMemoryEventBus()}
+	typeRegistry := cqrs.NewTypeRegistry()
+	typeRegistry.RegisterType(SampleMessageReceivedEvent{})
+	manager := cqrs.NewVersionedEventDispatchManager(bus, typeRegistry)
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(cqrs.VersionedEvent) error { return nil })
+
+	listener, err := manager.Listen(nil, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscription := manager.RegisterEventHandler(SampleEvent{}, func(cqrs.VersionedEvent) error { return nil })
+	if binding, _ := bus.lastBinding(); !reflect.DeepEqual(binding, []string{"cqrs_test.SampleEvent", "cqrs_test.SampleMessageReceivedEvent"}) {
+		t.Fatal("Expected a handler registered while listening to be bound but got", binding)
+	}
+
+	subscription.Unsubscribe()
+	if binding, _ := bus.lastBinding(); !reflect.DeepEqual(binding, []string{"cqrs_test.SampleMessageReceivedEvent"}) {
+		t.Fatal("Expected an unsubscribed handler to be unbound but got", binding)
+	}
+
+	if err := listener.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, count := bus.lastBinding()
+	manager.RegisterEventHandler(SampleEvent{}, func(cqrs.VersionedEvent) error { return nil })
+	if _, after := bus.lastBinding(); after != count {
+		t.Fatal("Expected no bindings after the listener shut down")
+	}
+}
+
+func TestIndependentVersionedEventDispatcherForgetsSucceededHandlers(t *testing.T) {
+	defer func(memory int) { cqrs.DefaultDispatchMemory = memory }(cqrs.DefaultDispatchMemory)
+	cqrs.DefaultDispatchMemory = 1
+
+	dispatcher := cqrs.NewVersionedEventDispatcher()
+	dispatcher.SetDispatchMode(cqrs.DispatchIndependent)
+	errUnavailable := errors.New("read model unavailable")
+	calls := map[string]int{}
+	failure := errUnavailable
+	dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		calls[event.ID]++
+		return nil
+	})
+	dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		return failure
+	})
+
+	dispatch := func(id string) error {
+		return dispatcher.DispatchEvent(cqrs.VersionedEvent{ID: id, Event: SampleMessageReceivedEvent{id}})
+	}
+
+	if err := dispatch("event-1"); !errors.Is(err, errUnavailable) {
+		t.Fatal("Expected the handler error to be found in the dispatch error but got", err)
+	}
+
+	// Remembering a second event forgets the first
+	_ = dispatch("event-2")
+	_ = dispatch("event-1")
+	if calls["event-1"] != 2 {
+		t.Fatal("Expected the succeeded handlers of the oldest event to be forgotten but got", calls)
+	}
+
+	// The succeeded handlers of a permanent failure stay remembered for requeueing its dead letter
+	failure = cqrs.NewPermanentError(errUnavailable)
+	if err := dispatch("event-3"); !cqrs.IsPermanent(err) {
+		t.Fatal("Expected a permanent dispatch error but got", err)
+	}
+
+	_ = dispatch("event-3")
+	if calls["event-3"] != 1 {
+		t.Fatal("Expected the succeeded handlers of a permanently failed event to be remembered but got", calls)
+	}
+}
+
+func TestVersionedEventDispatcherPermanentOnlyWhenEveryHandlerFailedPermanently(t *testing.T) {
+	dispatcher := cqrs.NewVersionedEventDispatcher()
+	dispatcher.SetDispatchMode(cqrs.DispatchIndependent)
+	calls := map[string]int{}
+	transient := errors.New("read model unavailable")
+	dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		calls["permanent"]++
+		return cqrs.NewPermanentError(errors.New("invalid event"))
+	})
+	dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		calls["transient"]++
+		return transient
+	})
+
+	event := cqrs.VersionedEvent{ID: "event-1", Event: SampleMessageReceivedEvent{"Hello world"}}
+	err := dispatcher.DispatchEvent(event)
+	if cqrs.IsPermanent(err) || !errors.Is(err, transient) {
+		t.Fatal("Expected a transient handler failure to keep the event retryable but got", err)
+	}
+
+	transient = cqrs.NewPermanentError(transient)
+	if err := dispatcher.DispatchEvent(event); !cqrs.IsPermanent(err) {
+		t.Fatal("Expected the event to fail permanently once every handler failed permanently but got", err)
+	}
+}
+
+func TestParallelVersionedEventDispatcherRecoversHandlerPanics(t *testing.T) {
+	dispatcher := cqrs.NewVersionedEventDispatcher()
+	dispatcher.SetDispatchMode(cqrs.DispatchParallel)
+	dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error { return nil })
+	dispatcher.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error { panic("read model corrupted") })
+
+	err := dispatcher.DispatchEvent(cqrs.VersionedEvent{ID: "event-1", Event: SampleMessageReceivedEvent{"Hello world"}})
+	var dispatchErr *cqrs.DispatchError
+	if !errors.As(err, &dispatchErr) || len(dispatchErr.Errors) != 1 {
+		t.Fatal("Expected the panicking handler to fail alone but got", err)
+	}
+}
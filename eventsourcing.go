@@ -2,6 +2,7 @@ package cqrs
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"time"
 )
@@ -15,6 +16,29 @@ type EventSourcingRepository interface {
 	GetSnapshot(id string) (EventSourced, error)
 }
 
+// TemporalRepository is optionally implemented by event sourcing repositories able to load past states of an aggregate.
+// The repositories returned by NewRepository implement it
+type TemporalRepository interface {
+	EventSourcingRepository
+	GetAt(id string, source EventSourced, version int) error
+	GetAsOf(id string, source EventSourced, asOf time.Time) error
+}
+
+// ErrAggregateVersionNotFound is returned when loading an aggregate at a version it never reached
+var ErrAggregateVersionNotFound = errors.New("aggregate version not found")
+
+// ErrAggregateNotCreatedYet is returned when loading an aggregate as of a time before its first event
+var ErrAggregateNotCreatedYet = errors.New("aggregate not created yet")
+
+// ErrAggregateAlreadyPast is returned when loading a past state into an aggregate that already applied later events
+var ErrAggregateAlreadyPast = errors.New("aggregate already loaded past the requested state")
+
+// SnapshotRestorer is implemented by aggregates able to copy their state from a snapshot.
+// Temporal queries start from a snapshot taken before the requested boundary when the aggregate implements it
+type SnapshotRestorer interface {
+	CopyFrom(snapshot interface{})
+}
+
 // EventStreamRepository is a persistance layer for events associated with aggregates by ID
 type EventStreamRepository interface {
 	VersionedEventPublicationLogger
@@ -24,6 +48,11 @@ type EventStreamRepository interface {
 	GetSnapshot(string) (EventSourced, error)
 }
 
+// eventsCreatedRecorder is implemented by aggregates recording when their new events were created, see EventSourceBased
+type eventsCreatedRecorder interface {
+	EventsCreated() []time.Time
+}
+
 type defaultEventSourcingRepository struct {
 	Registry        TypeRegistry
 	EventRepository EventStreamRepository
@@ -59,16 +88,27 @@ func (r defaultEventSourcingRepository) Save(source EventSourced, correlationID
 	currentVersion := source.Version() + 1
 	var latestVersion int
 	var events []VersionedEvent
-	for i, event := range source.Events() {
+	var created []time.Time
+	if recorder, ok := source.(eventsCreatedRecorder); ok {
+		created = recorder.EventsCreated()
+	}
+
+	newEvents := source.Events()
+	for i, event := range newEvents {
 		eventType := reflect.TypeOf(event)
 		latestVersion = currentVersion + i
+		eventCreated := time.Now().UTC()
+		if len(created) == len(newEvents) {
+			eventCreated = created[i]
+		}
+
 		versionedEvent := VersionedEvent{
 			ID:            "ve:" + NewUUIDString(),
 			CorrelationID: correlationID,
 			SourceID:      id,
 			Version:       latestVersion,
 			EventType:     eventType.String(),
-			Created:       time.Now().UTC(),
+			Created:       eventCreated,
 
 			Event: event}
 
@@ -155,7 +195,125 @@ func (r defaultEventSourcingRepository) Get(id string, source EventSourced) erro
 		return nil
 	}
 
-	start = time.Now()
+	return r.applyEvents(source, events)
+}
+
+// GetAt loads the state of an aggregate as of the given version.
+// An aggregate already loaded at that version is left as is, events cannot be unapplied from one loaded past it
+func (r defaultEventSourcingRepository) GetAt(id string, source EventSourced, version int) error {
+	PackageLogger().Debugf("defaultEventSourcingRepository.GetAt() - Get %s at version %v", id, version)
+
+	loaded := source.Version()
+	if version < loaded {
+		return fmt.Errorf("%w: %s version %d loaded at version %d", ErrAggregateAlreadyPast, id, version, loaded)
+	}
+
+	if version == loaded && loaded > 0 {
+		return nil
+	}
+
+	events, err := r.EventRepository.Get(id, loaded+1)
+	if err != nil {
+		return err
+	}
+
+	events = eventsAfterVersion(events, loaded)
+	boundary := 0
+	for boundary < len(events) && events[boundary].Version <= version {
+		boundary++
+	}
+
+	if boundary == 0 || events[boundary-1].Version != version {
+		return fmt.Errorf("%w: %s version %d", ErrAggregateVersionNotFound, id, version)
+	}
+
+	return r.applyEventsFromSnapshot(id, source, events[:boundary])
+}
+
+// GetAsOf loads the state of an aggregate as of the given time, applying only the events created until then.
+// An aggregate already loaded as of that time only applies the events it is missing
+func (r defaultEventSourcingRepository) GetAsOf(id string, source EventSourced, asOf time.Time) error {
+	PackageLogger().Debugf("defaultEventSourcingRepository.GetAsOf() - Get %s as of %v", id, asOf)
+
+	loaded := source.Version()
+	events, err := r.EventRepository.Get(id, loaded)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if event.Version <= loaded && event.Created.After(asOf) {
+			return fmt.Errorf("%w: %s as of %v loaded at version %d", ErrAggregateAlreadyPast, id, asOf, loaded)
+		}
+	}
+
+	events = eventsAfterVersion(events, loaded)
+	boundary := 0
+	for boundary < len(events) && !events[boundary].Created.After(asOf) {
+		boundary++
+	}
+
+	if boundary == 0 {
+		if loaded > 0 {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %s as of %v", ErrAggregateNotCreatedYet, id, asOf)
+	}
+
+	return r.applyEventsFromSnapshot(id, source, events[:boundary])
+}
+
+// eventsAfterVersion skips the events already applied to an aggregate, for event stores returning a stream from its start
+func eventsAfterVersion(events []VersionedEvent, version int) []VersionedEvent {
+	for i, event := range events {
+		if event.Version > version {
+			return events[i:]
+		}
+	}
+
+	return nil
+}
+
+// applyEventsFromSnapshot applies events to an aggregate, skipping those already contained in a snapshot.
+// The snapshot is only used when the aggregate can restore it and it was taken within the applied events
+func (r defaultEventSourcingRepository) applyEventsFromSnapshot(id string, source EventSourced, events []VersionedEvent) error {
+	restorer, ok := source.(SnapshotRestorer)
+	if !ok {
+		return r.applyEvents(source, events)
+	}
+
+	snapshot, err := r.EventRepository.GetSnapshot(id)
+	if err != nil || snapshot == nil {
+		return r.applyEvents(source, events)
+	}
+
+	snapshotVersion := snapshot.Version()
+	if snapshotVersion <= source.Version() || snapshotVersion > events[len(events)-1].Version {
+		return r.applyEvents(source, events)
+	}
+
+	PackageLogger().Debugf("defaultEventSourcingRepository.applyEventsFromSnapshot() - Restoring %s from snapshot version %v", id, snapshotVersion)
+	restorer.CopyFrom(snapshot)
+	source.SetVersion(snapshotVersion)
+
+	var remaining []VersionedEvent
+	for _, event := range events {
+		if event.Version > snapshotVersion {
+			remaining = append(remaining, event)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	return r.applyEvents(source, remaining)
+}
+
+// applyEvents routes events to the aggregate's event handlers and advances its version to the last event applied
+func (r defaultEventSourcingRepository) applyEvents(source EventSourced, events []VersionedEvent) error {
+	start := time.Now()
 
 	handlers := r.Registry.GetHandlers(source)
 	for _, event := range events {
@@ -167,12 +325,15 @@ func (r defaultEventSourcingRepository) Get(id string, source EventSourced) erro
 			return errors.New(errorMessage)
 		}
 
-		handler(source, event.Event)
+		if err := handler(source, event); err != nil {
+			PackageLogger().Debugf("defaultEventSourcingRepository.Get() - Error handling event %s: %v", event.EventType, err)
+			return err
+		}
 	}
 
 	source.SetVersion(events[len(events)-1].Version)
 
-	end = time.Now()
+	end := time.Now()
 	PackageLogger().Debugf("defaultEventSourcingRepository.Get() - Get Handlers Took [%dms]", end.Sub(start)/time.Millisecond)
 
 	return nil
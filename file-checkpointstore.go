@@ -0,0 +1,80 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// FileCheckpointStore provides a CheckpointStore persisting the checkpoints of all consumers to a JSON file
+type FileCheckpointStore struct {
+	lock        sync.Mutex
+	path        string
+	checkpoints map[string]Checkpoint
+}
+
+// NewFileCheckpointStore constructs a FileCheckpointStore loading any checkpoints previously persisted to path
+func NewFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	store := &FileCheckpointStore{path: path, checkpoints: make(map[string]Checkpoint)}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// SaveCheckpoint persists the checkpoint of the named consumer
+func (s *FileCheckpointStore) SaveCheckpoint(name string, checkpoint Checkpoint) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previous, existed := s.checkpoints[name]
+	s.checkpoints[name] = checkpoint
+	if err := s.flush(); err != nil {
+		if existed {
+			s.checkpoints[name] = previous
+		} else {
+			delete(s.checkpoints, name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint of the named consumer, or the zero checkpoint when none was saved
+func (s *FileCheckpointStore) GetCheckpoint(name string) (Checkpoint, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.checkpoints[name], nil
+}
+
+func (s *FileCheckpointStore) load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &s.checkpoints); err != nil {
+		return fmt.Errorf("json.Unmarshal checkpoints: %v", err)
+	}
+
+	return nil
+}
+
+func (s *FileCheckpointStore) flush() error {
+	data, err := json.Marshal(s.checkpoints)
+	if err != nil {
+		return fmt.Errorf("json.Marshal checkpoints: %v", err)
+	}
+
+	return writeFileAtomically(s.path, data)
+}
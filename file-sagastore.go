@@ -0,0 +1,119 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+type fileSagaState struct {
+	SagaState
+	PendingCommands []fileCommand `json:"pendingCommands,omitempty"`
+}
+
+// FileSagaStore provides a SagaStore persisting the state of all saga instances to a JSON file
+type FileSagaStore struct {
+	lock     sync.Mutex
+	path     string
+	registry TypeRegistry
+	sagas    map[string]SagaState
+}
+
+// NewFileSagaStore constructs a FileSagaStore loading any saga state previously persisted to path.
+// The types of commands awaiting publication must be registered with the type registry in order to be loaded
+func NewFileSagaStore(path string, registry TypeRegistry) (*FileSagaStore, error) {
+	store := &FileSagaStore{path: path, registry: registry, sagas: make(map[string]SagaState)}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// GetSaga returns the state of a saga instance or ErrSagaNotFound
+func (s *FileSagaStore) GetSaga(sagaType string, id string) (SagaState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.sagas[sagaType+"/"+id]
+	if !ok {
+		return SagaState{}, fmt.Errorf("%w for %s %s", ErrSagaNotFound, sagaType, id)
+	}
+
+	return state, nil
+}
+
+// SaveSaga persists the state of a saga instance, returning ErrSagaConcurrency when the stored version differs from expectedVersion
+func (s *FileSagaStore) SaveSaga(state SagaState, expectedVersion int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := state.SagaType + "/" + state.ID
+	previous, existed := s.sagas[key]
+	if previous.Version != expectedVersion {
+		return fmt.Errorf("%w for %s %s", ErrSagaConcurrency, state.SagaType, state.ID)
+	}
+
+	s.sagas[key] = state
+	if err := s.flush(); err != nil {
+		if existed {
+			s.sagas[key] = previous
+		} else {
+			delete(s.sagas, key)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileSagaStore) load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var raw []fileSagaState
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("json.Unmarshal sagas: %v", err)
+	}
+
+	for _, rawState := range raw {
+		state := rawState.SagaState
+		state.PendingCommands = nil
+		for _, rawCommand := range rawState.PendingCommands {
+			command, err := rawCommand.decode(s.registry)
+			if err != nil {
+				return err
+			}
+
+			state.PendingCommands = append(state.PendingCommands, command)
+		}
+
+		s.sagas[state.SagaType+"/"+state.ID] = state
+	}
+
+	return nil
+}
+
+// flush persists the state of all saga instances to the store file
+func (s *FileSagaStore) flush() error {
+	states := make([]SagaState, 0, len(s.sagas))
+	for _, state := range s.sagas {
+		states = append(states, state)
+	}
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		return fmt.Errorf("json.Marshal sagas: %v", err)
+	}
+
+	return writeFileAtomically(s.path, data)
+}
@@ -0,0 +1,175 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type fileScheduledCommand struct {
+	ID      string      `json:"id"`
+	Due     time.Time   `json:"due"`
+	Command fileCommand `json:"command"`
+}
+
+// fileCommand is a persisted command whose body is decoded once its type is known
+type fileCommand struct {
+	MessageID     string          `json:"messageID"`
+	CorrelationID string          `json:"correlationID"`
+	CommandType   string          `json:"commandType"`
+	Actor         string          `json:"actor"`
+	OnBehalfOf    string          `json:"onbehalfof"`
+	Created       time.Time       `json:"time"`
+	Body          json.RawMessage `json:"body"`
+}
+
+// decode returns the persisted command with its body decoded to the type registered for the command type
+func (c fileCommand) decode(registry TypeRegistry) (Command, error) {
+	commandType, ok := registry.GetTypeByName(c.CommandType)
+	if !ok {
+		return Command{}, errors.New("Cannot find command type " + c.CommandType)
+	}
+
+	commandValue := reflect.New(commandType)
+	if err := json.Unmarshal(c.Body, commandValue.Interface()); err != nil {
+		return Command{}, errors.New("Error deserializing command " + c.CommandType)
+	}
+
+	return Command{
+		MessageID:     c.MessageID,
+		CorrelationID: c.CorrelationID,
+		CommandType:   c.CommandType,
+		Actor:         c.Actor,
+		OnBehalfOf:    c.OnBehalfOf,
+		Created:       c.Created,
+		Body:          reflect.Indirect(commandValue).Interface()}, nil
+}
+
+// FileScheduledCommandStore provides a ScheduledCommandStore persisting scheduled commands to a JSON file
+type FileScheduledCommandStore struct {
+	lock     sync.Mutex
+	path     string
+	registry TypeRegistry
+	commands map[string]ScheduledCommand
+}
+
+// NewFileScheduledCommandStore constructs a FileScheduledCommandStore loading any commands previously persisted to path.
+// Command types must be registered with the type registry in order to be loaded
+func NewFileScheduledCommandStore(path string, registry TypeRegistry) (*FileScheduledCommandStore, error) {
+	store := &FileScheduledCommandStore{path: path, registry: registry, commands: make(map[string]ScheduledCommand)}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// SaveScheduledCommand persists a scheduled command
+func (s *FileScheduledCommandStore) SaveScheduledCommand(command ScheduledCommand) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.commands[command.ID] = command
+	if err := s.flush(); err != nil {
+		delete(s.commands, command.ID)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveScheduledCommand removes a scheduled command
+func (s *FileScheduledCommandStore) RemoveScheduledCommand(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	command, ok := s.commands[id]
+	if !ok {
+		return ErrScheduledCommandNotFound
+	}
+
+	delete(s.commands, id)
+	if err := s.flush(); err != nil {
+		s.commands[id] = command
+		return err
+	}
+
+	return nil
+}
+
+// GetDueScheduledCommands returns all commands due at the given time ordered by their due time
+func (s *FileScheduledCommandStore) GetDueScheduledCommands(now time.Time) ([]ScheduledCommand, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return dueScheduledCommands(s.commands, now), nil
+}
+
+func (s *FileScheduledCommandStore) load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var raw []fileScheduledCommand
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("json.Unmarshal scheduled commands: %v", err)
+	}
+
+	for _, rawCommand := range raw {
+		command, err := rawCommand.Command.decode(s.registry)
+		if err != nil {
+			return err
+		}
+
+		s.commands[rawCommand.ID] = ScheduledCommand{ID: rawCommand.ID, Due: rawCommand.Due, Command: command}
+	}
+
+	return nil
+}
+
+// flush persists all scheduled commands to the store file
+func (s *FileScheduledCommandStore) flush() error {
+	commands := make([]ScheduledCommand, 0, len(s.commands))
+	for _, command := range s.commands {
+		commands = append(commands, command)
+	}
+
+	data, err := json.Marshal(commands)
+	if err != nil {
+		return fmt.Errorf("json.Marshal scheduled commands: %v", err)
+	}
+
+	return writeFileAtomically(s.path, data)
+}
+
+// writeFileAtomically writes data to a temporary file before atomically replacing the file at path
+func writeFileAtomically(path string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,79 @@
+package cqrs
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBusFull is returned when publishing to a full in memory bus using the OverflowError policy
+var ErrBusFull = errors.New("in memory bus is full")
+
+// ErrPublishTimeout is returned when publishing to a full in memory bus does not complete within the publish timeout
+var ErrPublishTimeout = errors.New("timeout publishing to in memory bus")
+
+// OverflowPolicy decides what an in memory bus does when a message is published while its buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for space in the buffer, up to the publish timeout when one is set. This is the default
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered message to make space for the published message.
+	// Discarded messages are recorded in the dead letter store when one is set
+	OverflowDropOldest
+	// OverflowError fails the publish with ErrBusFull without waiting
+	OverflowError
+)
+
+// InMemoryBusOptions configures the buffering of the in memory buses
+type InMemoryBusOptions struct {
+	// Name labels the queue depth metrics of the bus
+	Name string
+	// BufferSize is the number of published messages buffered until received. Zero means publishers wait for a receiver
+	BufferSize int
+	// PublishTimeout bounds how long publishing waits for space using the OverflowBlock policy. Zero waits indefinitely
+	PublishTimeout time.Duration
+	// Overflow decides what happens when publishing to a full buffer
+	Overflow OverflowPolicy
+	// MaxAttempts is the number of times a received message is handled before it is dead lettered. Permanent failures are not
+	// retried. Zero means a single attempt
+	MaxAttempts int
+}
+
+// withDefaults names the bus, handles messages at least once and gives the drop oldest policy a buffer to drop from
+func (o InMemoryBusOptions) withDefaults(name string) InMemoryBusOptions {
+	if o.Name == "" {
+		o.Name = name
+	}
+
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 1
+	}
+
+	if o.Overflow == OverflowDropOldest && o.BufferSize < 1 {
+		o.BufferSize = 1
+	}
+
+	return o
+}
+
+// publishTimeout returns a channel signalling the publish timeout, or nil to wait indefinitely
+func (o InMemoryBusOptions) publishTimeout() <-chan time.Time {
+	if o.PublishTimeout <= 0 {
+		return nil
+	}
+
+	return time.After(o.PublishTimeout)
+}
+
+// attempt runs a handler until it succeeds, fails permanently or was attempted MaxAttempts times.
+// It returns the number of attempts made and the error of the last attempt
+func (o InMemoryBusOptions) attempt(handle func() error) (int, error) {
+	attempts := 0
+	for {
+		attempts++
+		err := handle()
+		if err == nil || IsPermanent(err) || attempts >= o.MaxAttempts {
+			return attempts, err
+		}
+	}
+}
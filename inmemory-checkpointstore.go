@@ -0,0 +1,31 @@
+package cqrs
+
+import "sync"
+
+// InMemoryCheckpointStore provides an inmemory implementation of the CheckpointStore interface
+type InMemoryCheckpointStore struct {
+	lock        sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+// NewInMemoryCheckpointStore constructor
+func NewInMemoryCheckpointStore() *InMemoryCheckpointStore {
+	return &InMemoryCheckpointStore{checkpoints: make(map[string]Checkpoint)}
+}
+
+// SaveCheckpoint persists the checkpoint of the named consumer
+func (s *InMemoryCheckpointStore) SaveCheckpoint(name string, checkpoint Checkpoint) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.checkpoints[name] = checkpoint
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint of the named consumer, or the zero checkpoint when none was saved
+func (s *InMemoryCheckpointStore) GetCheckpoint(name string) (Checkpoint, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.checkpoints[name], nil
+}
@@ -1,41 +1,176 @@
 package cqrs
 
+import (
+	"time"
+)
+
 // InMemoryCommandBus provides an inmemory implementation of the CommandPublisher CommandReceiver interfaces
 type InMemoryCommandBus struct {
-	publishedCommandsChannel chan Command
+	publishedCommandsChannel chan inMemoryCommand
 	startReceiving           bool
+	commandTimeout           time.Duration
+	deadLetterStore          DeadLetterStore
+	options                  InMemoryBusOptions
+}
+
+// inMemoryCommand carries a published command and optionally a channel awaiting its result
+type inMemoryCommand struct {
+	command Command
+	result  chan CommandResult
 }
 
 // NewInMemoryCommandBus constructor
 func NewInMemoryCommandBus() *InMemoryCommandBus {
-	publishedCommandsChannel := make(chan Command, 0)
-	return &InMemoryCommandBus{publishedCommandsChannel, false}
+	return NewInMemoryCommandBusWithOptions(InMemoryBusOptions{})
+}
+
+// NewInMemoryCommandBusWithOptions constructs an in memory command bus buffering published commands as configured by the options
+func NewInMemoryCommandBusWithOptions(options InMemoryBusOptions) *InMemoryCommandBus {
+	options = options.withDefaults("commands")
+	publishedCommandsChannel := make(chan inMemoryCommand, options.BufferSize)
+	return &InMemoryCommandBus{publishedCommandsChannel, false, DefaultCommandTimeout, NewInMemoryDeadLetterStore(), options}
+}
+
+// SetDeadLetterStore sets the store recording commands whose handlers fail. It defaults to an in memory dead letter store.
+// Without a store failed commands are only logged and counted
+func (bus *InMemoryCommandBus) SetDeadLetterStore(store DeadLetterStore) {
+	bus.deadLetterStore = store
+}
+
+// DeadLetterStore returns the store recording commands whose handlers fail
+func (bus *InMemoryCommandBus) DeadLetterStore() DeadLetterStore {
+	return bus.deadLetterStore
+}
+
+// SetCommandTimeout sets the duration SendCommand waits for a command result
+func (bus *InMemoryCommandBus) SetCommandTimeout(timeout time.Duration) {
+	bus.commandTimeout = timeout
 }
 
 // PublishCommands publishes Commands to the Command bus
 func (bus *InMemoryCommandBus) PublishCommands(commands []Command) error {
 	for _, command := range commands {
-		bus.publishedCommandsChannel <- command
+		if err := bus.publishCommand(inMemoryCommand{command: command}, bus.options.publishTimeout()); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// ReceiveCommands starts a go routine that monitors incoming Commands and routes them to a receiver channel specified within the options
-func (bus *InMemoryCommandBus) ReceiveCommands(options CommandReceiverOptions) error {
-	go func() {
+// SendCommand publishes a command to the command bus and waits for the result of its command handlers
+func (bus *InMemoryCommandBus) SendCommand(command Command) (CommandResult, error) {
+	timeout := time.After(bus.commandTimeout)
+	result := make(chan CommandResult, 1)
+
+	if err := bus.publishCommand(inMemoryCommand{command: command, result: result}, timeout); err != nil {
+		if err == ErrPublishTimeout {
+			return CommandResult{}, ErrCommandTimeout
+		}
+
+		return CommandResult{}, err
+	}
+
+	select {
+	case commandResult := <-result:
+		return commandResult, nil
+	case <-timeout:
+		return CommandResult{}, ErrCommandTimeout
+	}
+}
+
+// publishCommand buffers a command applying the overflow policy when the buffer is full
+func (bus *InMemoryCommandBus) publishCommand(published inMemoryCommand, timeout <-chan time.Time) error {
+	defer bus.updateQueueDepth()
+
+	switch bus.options.Overflow {
+	case OverflowError:
+		select {
+		case bus.publishedCommandsChannel <- published:
+			return nil
+		default:
+			return ErrBusFull
+		}
+
+	case OverflowDropOldest:
 		for {
 			select {
-			case ch := <-options.Close:
-				ch <- nil
-			case command := <-bus.publishedCommandsChannel:
-				err := options.ReceiveCommand(command)
-				if err != nil {
+			case bus.publishedCommandsChannel <- published:
+				return nil
+			default:
+			}
 
-				}
+			select {
+			case dropped := <-bus.publishedCommandsChannel:
+				bus.dropCommand(dropped)
+			default:
 			}
 		}
-	}()
+
+	default:
+		select {
+		case bus.publishedCommandsChannel <- published:
+			return nil
+		case <-timeout:
+			return ErrPublishTimeout
+		}
+	}
+}
+
+// dropCommand discards a buffered command, failing a sender waiting for its result
+func (bus *InMemoryCommandBus) dropCommand(dropped inMemoryCommand) {
+	PackageLogger().Debugf("InMemoryCommandBus.Dropped: %s %s", dropped.command.CommandType, dropped.command.MessageID)
+	metricsInMemoryDropped.WithLabelValues(bus.options.Name).Inc()
+	if bus.deadLetterStore != nil {
+		if errSave := bus.deadLetterStore.SaveDeadLetter(NewCommandDeadLetter(dropped.command, ErrBusFull)); errSave != nil {
+			PackageLogger().Debugf("ERROR saving dead letter: %v\n", errSave)
+		}
+	}
+
+	if dropped.result != nil {
+		dropped.result <- NewCommandResult(dropped.command, ErrBusFull)
+	}
+}
+
+func (bus *InMemoryCommandBus) updateQueueDepth() {
+	metricsInMemoryQueueDepth.WithLabelValues(bus.options.Name).Set(float64(len(bus.publishedCommandsChannel)))
+}
+
+// ReceiveCommands starts go routines, one per listener, that monitor incoming Commands and route them to a receiver channel specified within the options.
+// Each go routine exits after receiving a close request
+func (bus *InMemoryCommandBus) ReceiveCommands(options CommandReceiverOptions) error {
+	for n := 0; n < listenerCount(options.ListenerCount); n++ {
+		go func() {
+			for {
+				select {
+				case ch := <-options.Close:
+					ch <- nil
+					return
+				case published := <-bus.publishedCommandsChannel:
+					bus.updateQueueDepth()
+					attempts, err := bus.options.attempt(func() error { return options.ReceiveCommand(published.command) })
+					if err != nil {
+						deadLetter := NewCommandDeadLetter(published.command, err)
+						deadLetter.Attempts = attempts
+						saveInMemoryDeadLetter(bus.deadLetterStore, bus.options.Name, deadLetter)
+					}
+
+					if published.result != nil {
+						published.result <- NewCommandResult(published.command, err)
+					}
+				}
+			}
+		}()
+	}
 
 	return nil
 }
+
+// listenerCount returns the number of listener go routines to start, at least one
+func listenerCount(requested int) int {
+	if requested < 1 {
+		return 1
+	}
+
+	return requested
+}
@@ -78,3 +78,58 @@ func TestInMemoryCommandBus(t *testing.T) {
 	closeChannel <- closeResponse
 	<-closeResponse
 }
+
+func TestInMemoryCommandBusSendCommand(t *testing.T) {
+	bus := cqrs.NewInMemoryCommandBus()
+	bus.SetCommandTimeout(5 * time.Second)
+
+	closeChannel := make(chan chan error)
+	errorChannel := make(chan error)
+	commandHandler := func(command cqrs.Command) error {
+		if command.Body.(SampleCommand).Message == "reject" {
+			return errors.New("command rejected")
+		}
+
+		return nil
+	}
+
+	if err := bus.ReceiveCommands(cqrs.CommandReceiverOptions{TypeRegistry: nil, Close: closeChannel, Error: errorChannel, ReceiveCommand: commandHandler}); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := cqrs.CreateCommand(SampleCommand{"accept"})
+	result, err := bus.SendCommand(accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Success || result.MessageID != accepted.MessageID {
+		t.Fatal("Expected command to be accepted", result)
+	}
+
+	result, err = bus.SendCommand(cqrs.CreateCommand(SampleCommand{"reject"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Success || result.Err() == nil || result.Error != "command rejected" {
+		t.Fatal("Expected command to be rejected", result)
+	}
+
+	if err := (cqrs.CommandResult{MessageID: "mid:1"}).Err(); !errors.Is(err, cqrs.ErrCommandFailed) || err.Error() != "command failed: mid:1" {
+		t.Fatal("Expected a failure without an error message to be reported as a failed command but got", err)
+	}
+
+	closeResponse := make(chan error)
+	closeChannel <- closeResponse
+	<-closeResponse
+}
+
+func TestInMemoryCommandBusSendCommandTimeout(t *testing.T) {
+	bus := cqrs.NewInMemoryCommandBus()
+	bus.SetCommandTimeout(50 * time.Millisecond)
+
+	if _, err := bus.SendCommand(cqrs.CreateCommand(SampleCommand{"nobody listening"})); err != cqrs.ErrCommandTimeout {
+		t.Fatal("Expected command timeout but got", err)
+	}
+}
@@ -0,0 +1,89 @@
+package cqrs
+
+import (
+	"sort"
+	"sync"
+)
+
+// InMemoryDeadLetterStore provides an inmemory implementation of the DeadLetterStore interface
+type InMemoryDeadLetterStore struct {
+	lock        sync.Mutex
+	deadLetters map[string]DeadLetter
+}
+
+// NewInMemoryDeadLetterStore constructor
+func NewInMemoryDeadLetterStore() *InMemoryDeadLetterStore {
+	return &InMemoryDeadLetterStore{deadLetters: make(map[string]DeadLetter)}
+}
+
+// SaveDeadLetter persists a dead letter
+func (s *InMemoryDeadLetterStore) SaveDeadLetter(deadLetter DeadLetter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deadLetters[deadLetter.ID] = deadLetter
+	return nil
+}
+
+// GetDeadLetters returns all dead letters ordered by the time they were recorded
+func (s *InMemoryDeadLetterStore) GetDeadLetters() ([]DeadLetter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	deadLetters := make([]DeadLetter, 0, len(s.deadLetters))
+	for _, deadLetter := range s.deadLetters {
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	sort.Slice(deadLetters, func(i, j int) bool { return deadLetters[i].Created.Before(deadLetters[j].Created) })
+	return deadLetters, nil
+}
+
+// GetDeadLetter returns a dead letter by ID
+func (s *InMemoryDeadLetterStore) GetDeadLetter(id string) (DeadLetter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	deadLetter, ok := s.deadLetters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+
+	return deadLetter, nil
+}
+
+// RemoveDeadLetter removes a dead letter by ID
+func (s *InMemoryDeadLetterStore) RemoveDeadLetter(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.deadLetters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+
+	delete(s.deadLetters, id)
+	return nil
+}
+
+// PurgeDeadLetters removes all dead letters
+func (s *InMemoryDeadLetterStore) PurgeDeadLetters() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deadLetters = make(map[string]DeadLetter)
+	return nil
+}
+
+// saveInMemoryDeadLetter records a message that could not be handled by an in memory bus. Dead letters are always logged and
+// counted, and are kept in the store unless the bus has none
+func saveInMemoryDeadLetter(store DeadLetterStore, bus string, deadLetter DeadLetter) {
+	PackageLogger().Debugf("InMemoryBus.DeadLettered: %s %s after %d attempts: %s", bus, deadLetter.ID, deadLetter.Attempts, deadLetter.Error)
+	metricsInMemoryDeadLettered.WithLabelValues(bus).Inc()
+	if store == nil {
+		return
+	}
+
+	if errSave := store.SaveDeadLetter(deadLetter); errSave != nil {
+		PackageLogger().Debugf("ERROR saving dead letter: %v\n", errSave)
+	}
+}
@@ -1,41 +1,245 @@
 package cqrs
 
-// InMemoryEventBus provides an inmemory implementation of the VersionedEventPublisher VersionedEventReceiver interfaces
+import (
+	"errors"
+	"sync"
+)
+
+// ErrExclusiveSubscription is returned when receiving events from a subscription already received by an exclusive receiver,
+// or when receiving exclusively from a subscription that already has receivers
+var ErrExclusiveSubscription = errors.New("subscription is received exclusively")
+
+// InMemoryEventBus provides an inmemory implementation of the VersionedEventPublisher VersionedEventReceiver interfaces.
+// Like a fanout exchange every published event is delivered to each named subscription, while the receivers of a subscription compete for its events.
+// A subscription is removed once its last receiver closes
 type InMemoryEventBus struct {
-	publishedEventsChannel chan VersionedEvent
-	startReceiving         bool
+	lock          sync.Mutex
+	subscriptions map[string]*inMemorySubscription
+	// backlog buffers the events published while no subscription has receivers, until a subscription adopts it
+	backlog         *inMemorySubscription
+	deadLetterStore DeadLetterStore
+	options         InMemoryBusOptions
+}
+
+// inMemorySubscription buffers the events published to a named subscription until they are received
+type inMemorySubscription struct {
+	name      string
+	metric    string
+	events    chan VersionedEvent
+	receivers int
+	exclusive bool
+	// removed is closed when the subscription is removed, releasing publishers waiting for buffer space
+	removed chan struct{}
+}
+
+// inMemorySubscriber receives the events of a named subscription
+type inMemorySubscriber struct {
+	bus  *InMemoryEventBus
+	name string
 }
 
 // NewInMemoryEventBus constructor
 func NewInMemoryEventBus() *InMemoryEventBus {
-	publishedEventsChannel := make(chan VersionedEvent, 0)
-	return &InMemoryEventBus{publishedEventsChannel, false}
+	return NewInMemoryEventBusWithOptions(InMemoryBusOptions{})
+}
+
+// NewInMemoryEventBusWithOptions constructs an in memory event bus buffering published events as configured by the options
+func NewInMemoryEventBusWithOptions(options InMemoryBusOptions) *InMemoryEventBus {
+	return &InMemoryEventBus{subscriptions: make(map[string]*inMemorySubscription), deadLetterStore: NewInMemoryDeadLetterStore(), options: options.withDefaults("events")}
+}
+
+// SetDeadLetterStore sets the store recording events whose handlers fail. It defaults to an in memory dead letter store.
+// Without a store failed events are only logged and counted
+func (bus *InMemoryEventBus) SetDeadLetterStore(store DeadLetterStore) {
+	bus.deadLetterStore = store
+}
+
+// DeadLetterStore returns the store recording events whose handlers fail
+func (bus *InMemoryEventBus) DeadLetterStore() DeadLetterStore {
+	return bus.deadLetterStore
+}
+
+// Subscriber returns a receiver for the named subscription.
+// Every subscription receives each event published while it has receivers, while receivers of the same subscription compete for its events
+func (bus *InMemoryEventBus) Subscriber(name string) VersionedEventReceiver {
+	return &inMemorySubscriber{bus, name}
+}
+
+// subscriptionLocked returns the named subscription, creating it when missing.
+// A new subscription adopts the backlog, so events published before anyone received them, including those publishers are
+// still waiting to buffer, are delivered to the first subscription receiving events
+func (bus *InMemoryEventBus) subscriptionLocked(name string) *inMemorySubscription {
+	subscription, ok := bus.subscriptions[name]
+	if !ok {
+		metric := bus.options.Name
+		if name != "" {
+			metric = bus.options.Name + "." + name
+		}
+
+		subscription = &inMemorySubscription{name: name, metric: metric, events: make(chan VersionedEvent, bus.options.BufferSize), removed: make(chan struct{})}
+		if bus.backlog != nil {
+			subscription.events = bus.backlog.events
+			subscription.removed = bus.backlog.removed
+			bus.backlog = nil
+		}
+
+		bus.subscriptions[name] = subscription
+	}
+
+	return subscription
 }
 
-// PublishEvents publishes events to the event bus
+// PublishEvents publishes events to every subscription of the event bus with receivers.
+// When no subscription has receivers the events are kept in a backlog delivered to the first subscription receiving events
 func (bus *InMemoryEventBus) PublishEvents(events []VersionedEvent) error {
+	bus.lock.Lock()
+	subscriptions := make([]*inMemorySubscription, 0, len(bus.subscriptions))
+	for _, subscription := range bus.subscriptions {
+		if subscription.receivers > 0 {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	if len(subscriptions) == 0 {
+		if bus.backlog == nil {
+			bus.backlog = &inMemorySubscription{metric: bus.options.Name, events: make(chan VersionedEvent, bus.options.BufferSize), removed: make(chan struct{})}
+		}
+
+		subscriptions = append(subscriptions, bus.backlog)
+	}
+	bus.lock.Unlock()
+
 	for _, event := range events {
-		bus.publishedEventsChannel <- event
+		for _, subscription := range subscriptions {
+			if err := bus.publishEvent(subscription, event); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-// ReceiveEvents starts a go routine that monitors incoming events and routes them to a receiver channel specified within the options
-func (bus *InMemoryEventBus) ReceiveEvents(options VersionedEventReceiverOptions) error {
+// publishEvent buffers an event for a subscription applying the overflow policy when the buffer is full
+func (bus *InMemoryEventBus) publishEvent(subscription *inMemorySubscription, event VersionedEvent) error {
+	defer bus.updateQueueDepth(subscription)
+
+	switch bus.options.Overflow {
+	case OverflowError:
+		select {
+		case subscription.events <- event:
+			return nil
+		default:
+			return ErrBusFull
+		}
 
-	go func() {
+	case OverflowDropOldest:
 		for {
 			select {
-			case ch := <-options.Close:
-				ch <- nil
-			case versionedEvent := <-bus.publishedEventsChannel:
-				if err := options.ReceiveEvent(versionedEvent); err != nil {
+			case subscription.events <- event:
+				return nil
+			default:
+			}
 
-				}
+			select {
+			case dropped := <-subscription.events:
+				bus.dropEvent(subscription, dropped)
+			default:
 			}
 		}
-	}()
+
+	default:
+		select {
+		case subscription.events <- event:
+			return nil
+		case <-subscription.removed:
+			return nil
+		case <-bus.options.publishTimeout():
+			return ErrPublishTimeout
+		}
+	}
+}
+
+func (bus *InMemoryEventBus) dropEvent(subscription *inMemorySubscription, event VersionedEvent) {
+	PackageLogger().Debugf("InMemoryEventBus.Dropped: %s %s %s", subscription.metric, event.EventType, event.ID)
+	metricsInMemoryDropped.WithLabelValues(subscription.metric).Inc()
+	if bus.deadLetterStore != nil {
+		if errSave := bus.deadLetterStore.SaveDeadLetter(NewEventDeadLetter(event, ErrBusFull)); errSave != nil {
+			PackageLogger().Debugf("ERROR saving dead letter: %v\n", errSave)
+		}
+	}
+}
+
+func (bus *InMemoryEventBus) updateQueueDepth(subscription *inMemorySubscription) {
+	metricsInMemoryQueueDepth.WithLabelValues(subscription.metric).Set(float64(len(subscription.events)))
+}
+
+// ReceiveEvents receives the events of the default subscription. See Subscriber for receiving named subscriptions
+func (bus *InMemoryEventBus) ReceiveEvents(options VersionedEventReceiverOptions) error {
+	return bus.receiveEvents("", options)
+}
+
+// ReceiveEvents receives the events of the named subscription
+func (s *inMemorySubscriber) ReceiveEvents(options VersionedEventReceiverOptions) error {
+	return s.bus.receiveEvents(s.name, options)
+}
+
+// receiveEvents starts go routines, one per listener, that monitor the events of a subscription and route them to a receiver channel specified within the options.
+// Each go routine exits after receiving a close request
+func (bus *InMemoryEventBus) receiveEvents(name string, options VersionedEventReceiverOptions) error {
+	listeners := listenerCount(options.ListenerCount)
+
+	bus.lock.Lock()
+	subscription := bus.subscriptionLocked(name)
+	if subscription.exclusive || (options.Exclusive && subscription.receivers > 0) {
+		bus.lock.Unlock()
+		return ErrExclusiveSubscription
+	}
+
+	subscription.receivers += listeners
+	subscription.exclusive = options.Exclusive
+	bus.lock.Unlock()
+
+	for n := 0; n < listeners; n++ {
+		go func() {
+			for {
+				select {
+				case ch := <-options.Close:
+					bus.removeReceiver(subscription)
+					ch <- nil
+					return
+				case versionedEvent := <-subscription.events:
+					bus.updateQueueDepth(subscription)
+					if attempts, err := bus.options.attempt(func() error { return options.ReceiveEvent(versionedEvent) }); err != nil {
+						deadLetter := NewEventDeadLetter(versionedEvent, err)
+						deadLetter.Attempts = attempts
+						saveInMemoryDeadLetter(bus.deadLetterStore, subscription.metric, deadLetter)
+					}
+				}
+			}
+		}()
+	}
 
 	return nil
 }
+
+// removeReceiver releases a closed listener, removing the subscription once every listener of its receivers closed
+// so publishing no longer waits for it
+func (bus *InMemoryEventBus) removeReceiver(subscription *inMemorySubscription) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	subscription.receivers--
+	if subscription.receivers > 0 {
+		return
+	}
+
+	if bus.subscriptions[subscription.name] == subscription {
+		delete(bus.subscriptions, subscription.name)
+	}
+
+	close(subscription.removed)
+	if dropped := len(subscription.events); dropped > 0 {
+		PackageLogger().Debugf("InMemoryEventBus.Removed: %s discarding %d events", subscription.name, dropped)
+	}
+}
@@ -77,3 +77,208 @@ func TestInMemoryEventBus(t *testing.T) {
 	closeChannel <- closeResponse
 	<-closeResponse
 }
+
+func TestInMemoryEventBusOverflow(t *testing.T) {
+	events := func(messages ...string) []cqrs.VersionedEvent {
+		var versionedEvents []cqrs.VersionedEvent
+		for _, message := range messages {
+			versionedEvents = append(versionedEvents, cqrs.VersionedEvent{ID: message, Event: SampleEvent{message}})
+		}
+
+		return versionedEvents
+	}
+
+	bus := cqrs.NewInMemoryEventBusWithOptions(cqrs.InMemoryBusOptions{BufferSize: 2, Overflow: cqrs.OverflowError})
+	if err := bus.PublishEvents(events("first", "second")); err != nil {
+		t.Fatal("Expected events to be buffered without a receiver but got", err)
+	}
+
+	if err := bus.PublishEvents(events("third")); err != cqrs.ErrBusFull {
+		t.Fatal("Expected publishing to a full bus to fail but got", err)
+	}
+
+	bus = cqrs.NewInMemoryEventBusWithOptions(cqrs.InMemoryBusOptions{BufferSize: 1, PublishTimeout: 10 * time.Millisecond})
+	if err := bus.PublishEvents(events("first", "second")); err != cqrs.ErrPublishTimeout {
+		t.Fatal("Expected publishing to a full bus to time out but got", err)
+	}
+
+	deadLetters := cqrs.NewInMemoryDeadLetterStore()
+	bus = cqrs.NewInMemoryEventBusWithOptions(cqrs.InMemoryBusOptions{BufferSize: 1, Overflow: cqrs.OverflowDropOldest})
+	bus.SetDeadLetterStore(deadLetters)
+	if err := bus.PublishEvents(events("first", "second")); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, _ := deadLetters.GetDeadLetters()
+	if len(dropped) != 1 || dropped[0].Event.ID != "first" {
+		t.Fatal("Expected the oldest event to be dropped but got", dropped)
+	}
+
+	received := make(chan cqrs.VersionedEvent, 1)
+	closeChannel := make(chan chan error)
+	if err := bus.ReceiveEvents(cqrs.VersionedEventReceiverOptions{Close: closeChannel, Error: make(chan error), ReceiveEvent: func(event cqrs.VersionedEvent) error {
+		received <- event
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := <-received; event.ID != "second" {
+		t.Fatal("Expected the newest event to be kept but received", event.ID)
+	}
+
+	closeResponse := make(chan error)
+	closeChannel <- closeResponse
+	<-closeResponse
+}
+
+func TestInMemoryEventBusSubscribers(t *testing.T) {
+	bus := cqrs.NewInMemoryEventBusWithOptions(cqrs.InMemoryBusOptions{BufferSize: 10})
+	received := make(chan string, 10)
+	receive := func(receiver cqrs.VersionedEventReceiver, name string, exclusive bool) (chan chan error, error) {
+		closeChannel := make(chan chan error)
+		return closeChannel, receiver.ReceiveEvents(cqrs.VersionedEventReceiverOptions{
+			Close:         closeChannel,
+			Error:         make(chan error),
+			Exclusive:     exclusive,
+			ListenerCount: 1,
+			ReceiveEvent: func(event cqrs.VersionedEvent) error {
+				received <- name
+				return nil
+			}})
+	}
+
+	var closeChannels []chan chan error
+	for _, name := range []string{"accounts", "accounts", "users"} {
+		closeChannel, err := receive(bus.Subscriber(name), name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		closeChannels = append(closeChannels, closeChannel)
+	}
+
+	if _, err := receive(bus.Subscriber("users"), "users", true); err != cqrs.ErrExclusiveSubscription {
+		t.Fatal("Expected an exclusive receiver to be refused on a subscription with receivers but got", err)
+	}
+
+	if err := bus.PublishEvents([]cqrs.VersionedEvent{{Event: SampleEvent{"Hello world"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-received:
+			counts[name]++
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the event")
+		}
+	}
+
+	select {
+	case name := <-received:
+		t.Fatal("Expected competing receivers of a subscription to receive the event once but it was received again by", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if counts["accounts"] != 1 || counts["users"] != 1 {
+		t.Fatal("Expected every subscription to receive the event once but got", counts)
+	}
+
+	for _, closeChannel := range closeChannels {
+		closeResponse := make(chan error)
+		closeChannel <- closeResponse
+		<-closeResponse
+	}
+}
+
+func TestInMemoryEventBusClosedSubscriber(t *testing.T) {
+	// Unbuffered subscriptions without a publish timeout block publishing until the event is received
+	bus := cqrs.NewInMemoryEventBus()
+	bus.Subscriber("never-received")
+
+	received := make(chan cqrs.VersionedEvent, 10)
+	receive := func(name string) chan chan error {
+		closeChannel := make(chan chan error)
+		if err := bus.Subscriber(name).ReceiveEvents(cqrs.VersionedEventReceiverOptions{
+			Close:         closeChannel,
+			Error:         make(chan error),
+			ListenerCount: 1,
+			ReceiveEvent: func(event cqrs.VersionedEvent) error {
+				received <- event
+				return nil
+			}}); err != nil {
+			t.Fatal(err)
+		}
+
+		return closeChannel
+	}
+
+	receive("accounts")
+	users := receive("users")
+
+	closeResponse := make(chan error)
+	users <- closeResponse
+	if err := <-closeResponse; err != nil {
+		t.Fatal(err)
+	}
+
+	published := make(chan error, 1)
+	go func() {
+		published <- bus.PublishEvents([]cqrs.VersionedEvent{{Event: SampleEvent{"Hello world"}}})
+	}()
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected publishing to complete after a subscriber shut down")
+	}
+
+	if event := <-received; event.Event.(SampleEvent).Message != "Hello world" {
+		t.Fatal("Expected the remaining subscriber to receive the event but got", event)
+	}
+}
+
+func TestInMemoryEventBusPublishBeforeSubscribe(t *testing.T) {
+	// An event published before anyone subscribed waits for the first subscription receiving events
+	bus := cqrs.NewInMemoryEventBus()
+	published := make(chan error, 1)
+	go func() {
+		published <- bus.PublishEvents([]cqrs.VersionedEvent{{Event: SampleEvent{"Hello world"}}})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	received := make(chan cqrs.VersionedEvent, 1)
+	closeChannel := make(chan chan error)
+	if err := bus.Subscriber("a").ReceiveEvents(cqrs.VersionedEventReceiverOptions{
+		Close:         closeChannel,
+		Error:         make(chan error),
+		ListenerCount: 1,
+		ReceiveEvent: func(event cqrs.VersionedEvent) error {
+			received <- event
+			return nil
+		}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-received:
+		if event.Event.(SampleEvent).Message != "Hello world" {
+			t.Fatal("Expected the published event but got", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the subscriber to receive the event published before it subscribed")
+	}
+
+	if err := <-published; err != nil {
+		t.Fatal(err)
+	}
+
+	closeResponse := make(chan error)
+	closeChannel <- closeResponse
+	<-closeResponse
+}
@@ -13,6 +13,7 @@ type InMemoryEventStreamRepository struct {
 	correlation       map[string][]VersionedEvent
 	integrationEvents []VersionedEvent
 	eventSourcedStore map[string]EventSourced
+	streams           streamBroadcaster
 }
 
 // NewInMemoryEventStreamRepository constructor
@@ -20,31 +21,35 @@ func NewInMemoryEventStreamRepository() *InMemoryEventStreamRepository {
 	store := make(map[string][]VersionedEvent)
 	correlation := make(map[string][]VersionedEvent)
 	eventSourcedStore := make(map[string]EventSourced)
-	return &InMemoryEventStreamRepository{sync.Mutex{}, store, correlation, []VersionedEvent{}, eventSourcedStore}
+	return &InMemoryEventStreamRepository{sync.Mutex{}, store, correlation, []VersionedEvent{}, eventSourcedStore, streamBroadcaster{}}
 }
 
 // AllIntegrationEventsEverPublished returns all events ever published
 func (r *InMemoryEventStreamRepository) AllIntegrationEventsEverPublished() ([]VersionedEvent, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	log := r.integrationEvents
-	sort.Sort(ByCreated(log))
+	log := append([]VersionedEvent(nil), r.integrationEvents...)
+	sort.Stable(ByCreated(log))
 	return log, nil
 }
 
-// SaveIntegrationEvent persists an integration event
+// SaveIntegrationEvent persists an integration event and delivers it to the stream subscriptions
 func (r *InMemoryEventStreamRepository) SaveIntegrationEvent(event VersionedEvent) error {
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	r.saveIntegrationEventLocked(event)
+	r.lock.Unlock()
+
+	r.streams.publish([]VersionedEvent{event})
+	return nil
+}
 
+func (r *InMemoryEventStreamRepository) saveIntegrationEventLocked(event VersionedEvent) {
 	r.integrationEvents = append(r.integrationEvents, event)
 	events := r.correlation[event.CorrelationID]
 	events = append(events, event)
 	r.correlation[event.CorrelationID] = events
 
 	PackageLogger().Debugf("Saving SaveIntegrationEvent event ", event.CorrelationID, events)
-
-	return nil
 }
 
 // GetIntegrationEventsByCorrelationID returns all integration events with a matching correlationID
@@ -55,26 +60,24 @@ func (r *InMemoryEventStreamRepository) GetIntegrationEventsByCorrelationID(corr
 
 // Save persists an event sourced object into the repository
 func (r *InMemoryEventStreamRepository) Save(id string, newEvents []VersionedEvent) error {
+	r.lock.Lock()
 	for _, event := range newEvents {
-		if err := r.SaveIntegrationEvent(event); err != nil {
-			return err
-		}
+		r.saveIntegrationEventLocked(event)
 	}
 
-	r.lock.Lock()
-	defer r.lock.Unlock()
+	r.store[id] = append(r.store[id], newEvents...)
+	r.lock.Unlock()
 
-	if events, ok := r.store[id]; ok {
-		events = append(events, newEvents...)
-
-		r.store[id] = events
-		return nil
-	}
-
-	r.store[id] = newEvents
+	r.streams.publish(newEvents)
 	return nil
 }
 
+// SubscribeStream delivers the events matching the filter as soon as they are saved, including the integration events saved on their own
+// such as CQRS error events, just like polling the integration event log
+func (r *InMemoryEventStreamRepository) SubscribeStream(filter StreamFilter, bufferSize int) *StreamSubscription {
+	return r.streams.subscribe(filter, bufferSize)
+}
+
 // Get retrieves events assoicated with an event sourced object by ID
 func (r *InMemoryEventStreamRepository) Get(id string, fromVersion int) ([]VersionedEvent, error) {
 	r.lock.Lock()
@@ -0,0 +1,67 @@
+package cqrs
+
+import (
+	"time"
+)
+
+// InMemoryQueryBus provides an inmemory implementation of the QuerySender QueryReceiver interfaces
+type InMemoryQueryBus struct {
+	publishedQueriesChannel chan inMemoryQuery
+	queryTimeout            time.Duration
+}
+
+// inMemoryQuery carries a sent query and the channel awaiting its result
+type inMemoryQuery struct {
+	query  Query
+	result chan QueryResult
+}
+
+// NewInMemoryQueryBus constructor
+func NewInMemoryQueryBus() *InMemoryQueryBus {
+	return &InMemoryQueryBus{make(chan inMemoryQuery), DefaultQueryTimeout}
+}
+
+// SetQueryTimeout sets the duration SendQuery waits for a query result
+func (bus *InMemoryQueryBus) SetQueryTimeout(timeout time.Duration) {
+	bus.queryTimeout = timeout
+}
+
+// SendQuery sends a query to the query bus and waits for the result of its query handler
+func (bus *InMemoryQueryBus) SendQuery(query Query) (QueryResult, error) {
+	timeout := time.After(bus.queryTimeout)
+	result := make(chan QueryResult, 1)
+
+	select {
+	case bus.publishedQueriesChannel <- inMemoryQuery{query, result}:
+	case <-timeout:
+		return QueryResult{}, ErrQueryTimeout
+	}
+
+	select {
+	case queryResult := <-result:
+		return queryResult, nil
+	case <-timeout:
+		return QueryResult{}, ErrQueryTimeout
+	}
+}
+
+// ReceiveQueries starts go routines, one per listener, that monitor incoming queries and route them to a receiver channel specified within the options.
+// Each go routine exits after receiving a close request
+func (bus *InMemoryQueryBus) ReceiveQueries(options QueryReceiverOptions) error {
+	for n := 0; n < listenerCount(options.ListenerCount); n++ {
+		go func() {
+			for {
+				select {
+				case ch := <-options.Close:
+					ch <- nil
+					return
+				case sent := <-bus.publishedQueriesChannel:
+					result, err := options.ReceiveQuery(sent.query)
+					sent.result <- NewQueryResult(sent.query, result, err)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
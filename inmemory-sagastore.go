@@ -0,0 +1,44 @@
+package cqrs
+
+import (
+	"fmt"
+	"sync"
+)
+
+// InMemorySagaStore provides an inmemory implementation of the SagaStore interface
+type InMemorySagaStore struct {
+	lock  sync.Mutex
+	sagas map[string]SagaState
+}
+
+// NewInMemorySagaStore constructor
+func NewInMemorySagaStore() *InMemorySagaStore {
+	return &InMemorySagaStore{sagas: make(map[string]SagaState)}
+}
+
+// GetSaga returns the state of a saga instance or ErrSagaNotFound
+func (s *InMemorySagaStore) GetSaga(sagaType string, id string) (SagaState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.sagas[sagaType+"/"+id]
+	if !ok {
+		return SagaState{}, fmt.Errorf("%w for %s %s", ErrSagaNotFound, sagaType, id)
+	}
+
+	return state, nil
+}
+
+// SaveSaga persists the state of a saga instance, returning ErrSagaConcurrency when the stored version differs from expectedVersion
+func (s *InMemorySagaStore) SaveSaga(state SagaState, expectedVersion int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := state.SagaType + "/" + state.ID
+	if s.sagas[key].Version != expectedVersion {
+		return fmt.Errorf("%w for %s %s", ErrSagaConcurrency, state.SagaType, state.ID)
+	}
+
+	s.sagas[key] = state
+	return nil
+}
@@ -0,0 +1,60 @@
+package cqrs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// InMemoryScheduledCommandStore provides an inmemory implementation of the ScheduledCommandStore interface
+type InMemoryScheduledCommandStore struct {
+	lock     sync.Mutex
+	commands map[string]ScheduledCommand
+}
+
+// NewInMemoryScheduledCommandStore constructor
+func NewInMemoryScheduledCommandStore() *InMemoryScheduledCommandStore {
+	return &InMemoryScheduledCommandStore{commands: make(map[string]ScheduledCommand)}
+}
+
+// SaveScheduledCommand persists a scheduled command
+func (s *InMemoryScheduledCommandStore) SaveScheduledCommand(command ScheduledCommand) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.commands[command.ID] = command
+	return nil
+}
+
+// RemoveScheduledCommand removes a scheduled command
+func (s *InMemoryScheduledCommandStore) RemoveScheduledCommand(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.commands[id]; !ok {
+		return ErrScheduledCommandNotFound
+	}
+
+	delete(s.commands, id)
+	return nil
+}
+
+// GetDueScheduledCommands returns all commands due at the given time ordered by their due time
+func (s *InMemoryScheduledCommandStore) GetDueScheduledCommands(now time.Time) ([]ScheduledCommand, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return dueScheduledCommands(s.commands, now), nil
+}
+
+func dueScheduledCommands(commands map[string]ScheduledCommand, now time.Time) []ScheduledCommand {
+	var due []ScheduledCommand
+	for _, command := range commands {
+		if !command.Due.After(now) {
+			due = append(due, command)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].Due.Before(due[j].Due) })
+	return due
+}
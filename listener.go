@@ -0,0 +1,129 @@
+package cqrs
+
+import (
+	"context"
+	"sync"
+)
+
+// Listener is returned by the dispatch managers when they start listening and is used to shut the receiver down gracefully
+type Listener struct {
+	name          string
+	closeChannel  chan chan error
+	errorChannel  chan error
+	listenerCount int
+	observers     []ReceiverErrorObserver
+	flush         func() error
+	inFlight      sync.WaitGroup
+	shutdown      chan struct{}
+	shutdownOnce  sync.Once
+	done          chan struct{}
+	err           error
+}
+
+func newListener(name string, closeChannel chan chan error, errorChannel chan error, listenerCount int, observers []ReceiverErrorObserver) *Listener {
+	return &Listener{
+		name:          name,
+		closeChannel:  closeChannel,
+		errorChannel:  errorChannel,
+		listenerCount: listenerCount,
+		observers:     observers,
+		shutdown:      make(chan struct{}),
+		done:          make(chan struct{})}
+}
+
+// track wraps a handler so the listener can wait for messages being handled when shutting down
+func (l *Listener) track(handle func()) {
+	l.inFlight.Add(1)
+	defer l.inFlight.Done()
+	handle()
+}
+
+// reportError passes an error reported while receiving messages to the error observers
+func (l *Listener) reportError(err error) {
+	receiverErr := asReceiverError(err)
+	PackageLogger().Debugf("%s.ErrorReceived: %v", l.name, receiverErr)
+	metricsReceiverErrors.WithLabelValues(l.name, string(receiverErr.Kind)).Inc()
+	for _, observer := range l.observers {
+		observer(receiverErr)
+	}
+}
+
+// run processes errors reported by the receiver until stop is signalled or Shutdown is called
+func (l *Listener) run(stop <-chan bool) {
+	for {
+		// Wait on multiple channels using the select control flow.
+		select {
+		case <-stop:
+			l.shutdownOnce.Do(func() { close(l.shutdown) })
+			l.drain()
+			return
+		case <-l.shutdown:
+			l.drain()
+			return
+		// Receiving on this channel signifys an error has occured worker processor side
+		case err := <-l.errorChannel:
+			l.reportError(err)
+		}
+	}
+}
+
+// drain closes every receiver listener, waits for in-flight messages to be handled and records the final error
+func (l *Listener) drain() {
+	PackageLogger().Debugf("%s.Stopping", l.name)
+	listeners := l.listenerCount
+	if listeners < 1 {
+		listeners = 1
+	}
+
+	closeSignals := make([]chan error, 0, listeners)
+	for i := 0; i < listeners; i++ {
+		closeSignal := make(chan error, 1)
+		for sent := false; !sent; {
+			select {
+			case l.closeChannel <- closeSignal:
+				sent = true
+			case err := <-l.errorChannel:
+				l.reportError(err)
+			}
+		}
+
+		closeSignals = append(closeSignals, closeSignal)
+	}
+
+	for _, closeSignal := range closeSignals {
+		for received := false; !received; {
+			select {
+			case err := <-closeSignal:
+				received = true
+				if err != nil && l.err == nil {
+					l.err = err
+				}
+			case err := <-l.errorChannel:
+				l.reportError(err)
+			}
+		}
+	}
+
+	l.inFlight.Wait()
+	if l.flush != nil {
+		if err := l.flush(); err != nil && l.err == nil {
+			l.err = err
+		}
+	}
+
+	PackageLogger().Debugf("%s.Stopped", l.name)
+	close(l.done)
+}
+
+// Shutdown stops the receiver consuming messages and waits for messages already being handled to be acknowledged or rejected.
+// It returns the error reported while closing the receiver, or the context's error when the context is done first
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.shutdownOnce.Do(func() { close(l.shutdown) })
+
+	select {
+	case <-l.done:
+		return l.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package cqrs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+func TestListenerShutdownWaitsForInFlightEvents(t *testing.T) {
+	bus := cqrs.NewInMemoryEventBus()
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+
+	started := make(chan bool)
+	release := make(chan bool)
+	handled := false
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		started <- true
+		<-release
+		handled = true
+		return nil
+	})
+
+	listener, err := manager.Listen(nil, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_ = bus.PublishEvents([]cqrs.VersionedEvent{{Event: SampleMessageReceivedEvent{"Hello world"}}})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Expected shutdown to wait for the in-flight event but got", err)
+	}
+
+	close(release)
+	if err := listener.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !handled {
+		t.Fatal("Expected the in-flight event to be handled before shutdown completed")
+	}
+}
+
+func TestReceiverErrorObserverReportsHandlerFailures(t *testing.T) {
+	bus := cqrs.NewInMemoryEventBus()
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+
+	errReadModel := errors.New("read model unavailable")
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		return errReadModel
+	})
+
+	reported := make(chan *cqrs.ReceiverError, 1)
+	manager.OnError(func(err *cqrs.ReceiverError) {
+		reported <- err
+	})
+
+	listener, err := manager.Listen(nil, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Shutdown(context.Background())
+
+	event := cqrs.VersionedEvent{ID: "event-1", EventType: "SampleMessageReceivedEvent", Event: SampleMessageReceivedEvent{"Hello world"}}
+	if err := bus.PublishEvents([]cqrs.VersionedEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-reported:
+		if err.Kind != cqrs.ReceiverErrorHandler || err.MessageID != "event-1" || !errors.Is(err, errReadModel) {
+			t.Fatal("Expected the handler failure to be reported but got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the handler failure to be reported")
+	}
+}
@@ -3,10 +3,25 @@ package cqrs
 import "github.com/prometheus/client_golang/prometheus"
 
 var (
-	metricsCommandsDispatched *prometheus.CounterVec
-	metricsCommandsFailed     *prometheus.CounterVec
-	metricsEventsDispatched   *prometheus.CounterVec
-	metricsEventsFailed       *prometheus.CounterVec
+	metricsCommandsDispatched   *prometheus.CounterVec
+	metricsCommandsFailed       *prometheus.CounterVec
+	metricsCommandsInvalid      *prometheus.CounterVec
+	metricsCommandsUnhandled    *prometheus.CounterVec
+	metricsCommandsUnauthorized *prometheus.CounterVec
+	metricsEventsDispatched     *prometheus.CounterVec
+	metricsEventsFailed         *prometheus.CounterVec
+	metricsCommandsDuration     *prometheus.HistogramVec
+	metricsEventsDuration       *prometheus.HistogramVec
+	metricsReceiverErrors       *prometheus.CounterVec
+	metricsInMemoryQueueDepth   *prometheus.GaugeVec
+	metricsInMemoryDropped      *prometheus.CounterVec
+	metricsInMemoryDeadLettered *prometheus.CounterVec
+	metricsQueriesDispatched    *prometheus.CounterVec
+	metricsQueriesFailed        *prometheus.CounterVec
+	metricsQueriesDuration      *prometheus.HistogramVec
+	metricsSagasStarted         *prometheus.CounterVec
+	metricsSagasCompleted       *prometheus.CounterVec
+	metricsSagaConflicts        *prometheus.CounterVec
 )
 
 func init() {
@@ -22,6 +37,24 @@ func init() {
 		Help:      "CQRS Commands Failed",
 	}, []string{"command"})
 
+	metricsCommandsInvalid = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_commands_invalid",
+		Subsystem: "ix",
+		Help:      "CQRS Commands Failed Validation",
+	}, []string{"command"})
+
+	metricsCommandsUnhandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_commands_unhandled",
+		Subsystem: "ix",
+		Help:      "CQRS Commands Without Command Handler",
+	}, []string{"command"})
+
+	metricsCommandsUnauthorized = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_commands_unauthorized",
+		Subsystem: "ix",
+		Help:      "CQRS Commands Denied Authorization",
+	}, []string{"command"})
+
 	metricsEventsDispatched = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name:      "cqrs_events_dispatched",
 		Subsystem: "ix",
@@ -34,5 +67,77 @@ func init() {
 		Help:      "CQRS Events Failed",
 	}, []string{"event"})
 
-	prometheus.MustRegister(metricsCommandsDispatched, metricsCommandsFailed, metricsEventsDispatched, metricsEventsFailed)
+	metricsCommandsDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "cqrs_commands_duration_seconds",
+		Subsystem: "ix",
+		Help:      "CQRS Commands Handling Duration",
+	}, []string{"command"})
+
+	metricsEventsDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "cqrs_events_duration_seconds",
+		Subsystem: "ix",
+		Help:      "CQRS Events Handling Duration",
+	}, []string{"event"})
+
+	metricsReceiverErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_receiver_errors",
+		Subsystem: "ix",
+		Help:      "CQRS Errors Reported By Receivers",
+	}, []string{"receiver", "kind"})
+
+	metricsInMemoryQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "cqrs_inmemory_queue_depth",
+		Subsystem: "ix",
+		Help:      "CQRS In Memory Bus Buffered Messages",
+	}, []string{"bus"})
+
+	metricsInMemoryDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_inmemory_dropped",
+		Subsystem: "ix",
+		Help:      "CQRS In Memory Bus Messages Dropped On Overflow",
+	}, []string{"bus"})
+
+	metricsInMemoryDeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_inmemory_dead_lettered",
+		Subsystem: "ix",
+		Help:      "CQRS In Memory Bus Messages Dead Lettered After Their Handlers Failed",
+	}, []string{"bus"})
+
+	metricsQueriesDispatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_queries_dispatched",
+		Subsystem: "ix",
+		Help:      "CQRS Queries Dispatched",
+	}, []string{"query"})
+
+	metricsQueriesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_queries_failed",
+		Subsystem: "ix",
+		Help:      "CQRS Queries Failed",
+	}, []string{"query"})
+
+	metricsQueriesDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "cqrs_queries_duration_seconds",
+		Subsystem: "ix",
+		Help:      "CQRS Queries Handling Duration",
+	}, []string{"query"})
+
+	metricsSagasStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_sagas_started",
+		Subsystem: "ix",
+		Help:      "CQRS Sagas Started",
+	}, []string{"saga"})
+
+	metricsSagasCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_sagas_completed",
+		Subsystem: "ix",
+		Help:      "CQRS Sagas Completed",
+	}, []string{"saga"})
+
+	metricsSagaConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "cqrs_saga_conflicts",
+		Subsystem: "ix",
+		Help:      "CQRS Saga Concurrency Conflicts",
+	}, []string{"saga"})
+
+	prometheus.MustRegister(metricsCommandsDispatched, metricsCommandsFailed, metricsCommandsInvalid, metricsCommandsUnhandled, metricsCommandsUnauthorized, metricsEventsDispatched, metricsEventsFailed, metricsCommandsDuration, metricsEventsDuration, metricsReceiverErrors, metricsInMemoryQueueDepth, metricsInMemoryDropped, metricsInMemoryDeadLettered, metricsQueriesDispatched, metricsQueriesFailed, metricsQueriesDuration, metricsSagasStarted, metricsSagasCompleted, metricsSagaConflicts)
 }
@@ -0,0 +1,192 @@
+package cqrs
+
+import (
+	"fmt"
+	"time"
+)
+
+// CommandMiddleware decorates a command handler with cross cutting behaviour such as logging, tracing or recovery
+type CommandMiddleware func(CommandHandler) CommandHandler
+
+// VersionedEventMiddleware decorates a versioned event handler with cross cutting behaviour such as logging, tracing or recovery
+type VersionedEventMiddleware func(VersionedEventHandler) VersionedEventHandler
+
+// QueryMiddleware decorates a query handler with cross cutting behaviour such as logging, tracing or recovery
+type QueryMiddleware func(QueryHandler) QueryHandler
+
+// ChainCommandMiddleware wraps a command handler with the given middleware. The first middleware is the outermost
+func ChainCommandMiddleware(handler CommandHandler, middleware ...CommandMiddleware) CommandHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// ChainVersionedEventMiddleware wraps a versioned event handler with the given middleware. The first middleware is the outermost
+func ChainVersionedEventMiddleware(handler VersionedEventHandler, middleware ...VersionedEventMiddleware) VersionedEventHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// CommandRecoveryMiddleware converts a panic raised while handling a command into an error
+func CommandRecoveryMiddleware() CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(command Command) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					PackageLogger().Debugf("Recovered from panic handling command %s: %v", command.CommandType, r)
+					err = fmt.Errorf("panic handling command %s: %v", command.CommandType, r)
+				}
+			}()
+
+			return next(command)
+		}
+	}
+}
+
+// CommandTimingMiddleware records the duration of handling a command
+func CommandTimingMiddleware() CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(command Command) error {
+			start := time.Now()
+			err := next(command)
+			metricsCommandsDuration.WithLabelValues(command.CommandType).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// CommandLoggingMiddleware logs the outcome of handling a command. If logger is nil the package logger is used
+func CommandLoggingMiddleware(logger Logger) CommandMiddleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(command Command) error {
+			log := logger
+			if log == nil {
+				log = PackageLogger()
+			}
+
+			start := time.Now()
+			err := next(command)
+			if err != nil {
+				log.Printf("Command %s [%s] failed after %s: %v", command.CommandType, command.CorrelationID, time.Since(start), err)
+			} else {
+				log.Printf("Command %s [%s] handled in %s", command.CommandType, command.CorrelationID, time.Since(start))
+			}
+
+			return err
+		}
+	}
+}
+
+// VersionedEventRecoveryMiddleware converts a panic raised while handling an event into an error
+func VersionedEventRecoveryMiddleware() VersionedEventMiddleware {
+	return func(next VersionedEventHandler) VersionedEventHandler {
+		return func(event VersionedEvent) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					PackageLogger().Debugf("Recovered from panic handling event %s: %v", event.EventType, r)
+					err = fmt.Errorf("panic handling event %s: %v", event.EventType, r)
+				}
+			}()
+
+			return next(event)
+		}
+	}
+}
+
+// VersionedEventTimingMiddleware records the duration of handling an event
+func VersionedEventTimingMiddleware() VersionedEventMiddleware {
+	return func(next VersionedEventHandler) VersionedEventHandler {
+		return func(event VersionedEvent) error {
+			start := time.Now()
+			err := next(event)
+			metricsEventsDuration.WithLabelValues(event.EventType).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// VersionedEventLoggingMiddleware logs the outcome of handling an event. If logger is nil the package logger is used
+func VersionedEventLoggingMiddleware(logger Logger) VersionedEventMiddleware {
+	return func(next VersionedEventHandler) VersionedEventHandler {
+		return func(event VersionedEvent) error {
+			log := logger
+			if log == nil {
+				log = PackageLogger()
+			}
+
+			start := time.Now()
+			err := next(event)
+			if err != nil {
+				log.Printf("Event %s [%s] failed after %s: %v", event.EventType, event.CorrelationID, time.Since(start), err)
+			} else {
+				log.Printf("Event %s [%s] handled in %s", event.EventType, event.CorrelationID, time.Since(start))
+			}
+
+			return err
+		}
+	}
+}
+
+// ChainQueryMiddleware wraps a query handler with the given middleware. The first middleware is the outermost
+func ChainQueryMiddleware(handler QueryHandler, middleware ...QueryMiddleware) QueryHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// QueryRecoveryMiddleware converts a panic raised while handling a query into an error
+func QueryRecoveryMiddleware() QueryMiddleware {
+	return func(next QueryHandler) QueryHandler {
+		return func(query Query) (result interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					PackageLogger().Debugf("Recovered from panic handling query %s: %v", query.QueryType, r)
+					result, err = nil, fmt.Errorf("panic handling query %s: %v", query.QueryType, r)
+				}
+			}()
+
+			return next(query)
+		}
+	}
+}
+
+// QueryTimingMiddleware records the duration of handling a query
+func QueryTimingMiddleware() QueryMiddleware {
+	return func(next QueryHandler) QueryHandler {
+		return func(query Query) (interface{}, error) {
+			start := time.Now()
+			result, err := next(query)
+			metricsQueriesDuration.WithLabelValues(query.QueryType).Observe(time.Since(start).Seconds())
+			return result, err
+		}
+	}
+}
+
+// QueryLoggingMiddleware logs the outcome of handling a query. If logger is nil the package logger is used
+func QueryLoggingMiddleware(logger Logger) QueryMiddleware {
+	return func(next QueryHandler) QueryHandler {
+		return func(query Query) (interface{}, error) {
+			log := logger
+			if log == nil {
+				log = PackageLogger()
+			}
+
+			start := time.Now()
+			result, err := next(query)
+			if err != nil {
+				log.Printf("Query %s [%s] failed after %s: %v", query.QueryType, query.CorrelationID, time.Since(start), err)
+			} else {
+				log.Printf("Query %s [%s] handled in %s", query.QueryType, query.CorrelationID, time.Since(start))
+			}
+
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cqrs_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+func TestChainCommandMiddleware(t *testing.T) {
+	var calls []string
+	tracing := func(name string) cqrs.CommandMiddleware {
+		return func(next cqrs.CommandHandler) cqrs.CommandHandler {
+			return func(command cqrs.Command) error {
+				calls = append(calls, name)
+				return next(command)
+			}
+		}
+	}
+
+	handler := cqrs.ChainCommandMiddleware(func(command cqrs.Command) error {
+		calls = append(calls, "handler")
+		panic("boom")
+	}, tracing("first"), cqrs.CommandRecoveryMiddleware(), tracing("second"), cqrs.CommandTimingMiddleware())
+
+	if err := handler(cqrs.CreateCommand(SampleMessageCommand{"Hello world"})); err == nil {
+		t.Fatal("Expected panic to be recovered as an error")
+	}
+
+	if len(calls) != 3 || calls[0] != "first" || calls[1] != "second" || calls[2] != "handler" {
+		t.Fatal("Unexpected middleware order", calls)
+	}
+}
+
+func TestVersionedEventDispatchManagerMiddleware(t *testing.T) {
+	bus := cqrs.NewInMemoryEventBus()
+	manager := cqrs.NewVersionedEventDispatchManager(bus, cqrs.NewTypeRegistry())
+
+	handled := make(chan error, 1)
+	manager.Use(func(next cqrs.VersionedEventHandler) cqrs.VersionedEventHandler {
+		return func(event cqrs.VersionedEvent) error {
+			err := next(event)
+			handled <- err
+			return err
+		}
+	}, cqrs.VersionedEventRecoveryMiddleware(), cqrs.VersionedEventLoggingMiddleware(nil))
+
+	manager.RegisterEventHandler(SampleMessageReceivedEvent{}, func(event cqrs.VersionedEvent) error {
+		return errors.New("handler failed")
+	})
+
+	stop := make(chan bool)
+	if _, err := manager.Listen(stop, false, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bus.PublishEvents([]cqrs.VersionedEvent{{Event: SampleMessageReceivedEvent{"Hello world"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-handled:
+		if err == nil || err.Error() != "handler failed" {
+			t.Fatal("Expected handler error to pass through middleware", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Test timed out")
+	}
+
+	stop <- true
+}
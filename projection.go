@@ -0,0 +1,186 @@
+package cqrs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrProjectionRunning is returned when starting a projection runner that is already running
+var ErrProjectionRunning = errors.New("projection is already running")
+
+// Projection builds a read model from versioned events
+type Projection interface {
+	Name() string
+	Handle(VersionedEvent) error
+}
+
+// ProjectionRunner keeps a projection up to date. Starting the runner subscribes to live events, catches up on the events
+// stored in the integration event log since the projection's checkpoint and then switches to handling live events.
+// Live events received while catching up are buffered, and events received both from the log and live are handled once.
+// The checkpoint never passes an event the projection failed to handle, so a restarted runner resumes from it. The receiver must
+// therefore redeliver failed events, otherwise ErrCheckpointStuck is reported to the OnError observers
+type ProjectionRunner struct {
+	projection   Projection
+	log          VersionedEventPublicationLogger
+	receiver     VersionedEventReceiver
+	checkpointer *eventCheckpointer
+	typeRegistry TypeRegistry
+	observers    []ReceiverErrorObserver
+
+	// handling serializes passing events to the projection
+	handling   sync.Mutex
+	lock       sync.Mutex
+	catchingUp bool
+	buffered   []VersionedEvent
+	running    bool
+	listener   *Listener
+}
+
+// NewProjectionRunner is a constructor for the ProjectionRunner
+func NewProjectionRunner(projection Projection, log VersionedEventPublicationLogger, receiver VersionedEventReceiver, checkpoints CheckpointStore, registry TypeRegistry) *ProjectionRunner {
+	return &ProjectionRunner{projection: projection, log: log, receiver: receiver, checkpointer: newEventCheckpointer(projection.Name(), checkpoints, 1), typeRegistry: registry}
+}
+
+// OnError registers an observer called with every error reported while receiving live events, such as a stuck checkpoint.
+// Observers must be registered before calling Start
+func (r *ProjectionRunner) OnError(observer ReceiverErrorObserver) {
+	r.observers = append(r.observers, observer)
+}
+
+// Checkpoint returns the checkpoint of the events handled by the projection
+func (r *ProjectionRunner) Checkpoint() Checkpoint {
+	return r.checkpointer.current()
+}
+
+// Start resumes the projection from its persisted checkpoint. It returns once the projection caught up with the event log,
+// after which live events are handled as they are received. A stopped runner can be started again
+func (r *ProjectionRunner) Start() error {
+	r.lock.Lock()
+	if r.running {
+		r.lock.Unlock()
+		return ErrProjectionRunning
+	}
+
+	if _, err := r.checkpointer.load(); err != nil {
+		r.lock.Unlock()
+		return err
+	}
+
+	r.running = true
+	r.catchingUp = true
+	r.buffered = nil
+	r.lock.Unlock()
+
+	// Subscribe before reading the log so no event published while catching up is missed
+	closeChannel := make(chan chan error)
+	errorChannel := make(chan error)
+	listener := newListener("ProjectionRunner."+r.projection.Name(), closeChannel, errorChannel, 1, r.observers)
+	r.checkpointer.reportStuck(func(err error) { listener.reportError(NewReceiverError(ReceiverErrorCheckpoint, "", err)) })
+	options := VersionedEventReceiverOptions{TypeRegistry: r.typeRegistry, Close: closeChannel, Error: errorChannel, ReceiveEvent: r.receiveEvent, ListenerCount: 1}
+	if err := r.receiver.ReceiveEvents(options); err != nil {
+		r.stopped()
+		return err
+	}
+
+	go listener.run(nil)
+
+	err := r.catchUp()
+	if err == nil {
+		err = r.goLive(listener)
+	}
+
+	if err != nil {
+		_ = listener.Shutdown(context.Background())
+		r.stopped()
+	}
+
+	return err
+}
+
+func (r *ProjectionRunner) stopped() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.running = false
+	r.listener = nil
+}
+
+// goLive handles the live events buffered while catching up and switches to handling live events as they are received.
+// The buffer is only locked to take the buffered events, so live events keep being buffered while earlier ones are handled
+func (r *ProjectionRunner) goLive(listener *Listener) error {
+	for {
+		r.lock.Lock()
+		buffered := r.buffered
+		r.buffered = nil
+		if len(buffered) == 0 {
+			r.catchingUp = false
+			r.listener = listener
+			r.lock.Unlock()
+			return nil
+		}
+		r.lock.Unlock()
+
+		for _, event := range buffered {
+			if err := r.handleLive(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// catchUp handles the events stored in the log since the checkpoint
+func (r *ProjectionRunner) catchUp() error {
+	log, err := r.log.AllIntegrationEventsEverPublished()
+	if err != nil {
+		return err
+	}
+
+	return r.checkpointer.catchUp(log, r.handle)
+}
+
+// receiveEvent buffers live events while catching up and handles them afterwards
+func (r *ProjectionRunner) receiveEvent(event VersionedEvent) error {
+	r.lock.Lock()
+	if r.catchingUp {
+		r.buffered = append(r.buffered, event)
+		r.lock.Unlock()
+		return nil
+	}
+	r.lock.Unlock()
+
+	return r.handleLive(event)
+}
+
+// handleLive handles a live event unless it was already handled while catching up
+func (r *ProjectionRunner) handleLive(event VersionedEvent) error {
+	if r.checkpointer.handledWhileCatchingUp(event) {
+		return nil
+	}
+
+	return r.checkpointer.dispatch(event, r.handle)
+}
+
+// handle passes an event to the projection
+func (r *ProjectionRunner) handle(event VersionedEvent) error {
+	r.handling.Lock()
+	defer r.handling.Unlock()
+
+	return r.projection.Handle(event)
+}
+
+// Stop stops handling live events, waiting for an event being handled to finish
+func (r *ProjectionRunner) Stop() error {
+	r.lock.Lock()
+	listener := r.listener
+	r.lock.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+
+	err := listener.Shutdown(context.Background())
+	r.stopped()
+
+	return err
+}
@@ -0,0 +1,162 @@
+package cqrs_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type MessageCountProjection struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (p *MessageCountProjection) Name() string {
+	return "message-count"
+}
+
+func (p *MessageCountProjection) Handle(event cqrs.VersionedEvent) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.messages = append(p.messages, event.Event.(SampleMessageReceivedEvent).Message)
+	return nil
+}
+
+func (p *MessageCountProjection) Messages() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.messages...)
+}
+
+// redeliveringReceiver delivers events as soon as receiving starts, as if they were published live just after subscribing
+type redeliveringReceiver struct {
+	cqrs.VersionedEventReceiver
+	events []cqrs.VersionedEvent
+}
+
+func (r *redeliveringReceiver) ReceiveEvents(options cqrs.VersionedEventReceiverOptions) error {
+	for _, event := range r.events {
+		if err := options.ReceiveEvent(event); err != nil {
+			return err
+		}
+	}
+
+	return r.VersionedEventReceiver.ReceiveEvents(options)
+}
+
+func TestProjectionRunnerCatchesUpAndTailsLiveEvents(t *testing.T) {
+	log := cqrs.NewInMemoryEventStreamRepository()
+	bus := cqrs.NewInMemoryEventBusWithOptions(cqrs.InMemoryBusOptions{BufferSize: 10})
+	checkpoints := cqrs.NewInMemoryCheckpointStore()
+	receiver := bus.Subscriber("projections")
+
+	event := func(id string) cqrs.VersionedEvent {
+		return cqrs.VersionedEvent{ID: id, Created: time.Now(), Event: SampleMessageReceivedEvent{id}}
+	}
+
+	// An event stored before the projection started and delivered live once it subscribed is received from the log and live
+	stored := event("stored")
+	_ = log.SaveIntegrationEvent(stored)
+
+	projection := &MessageCountProjection{}
+	runner := cqrs.NewProjectionRunner(projection, log, &redeliveringReceiver{receiver, []cqrs.VersionedEvent{stored}}, checkpoints, cqrs.NewTypeRegistry())
+	if err := runner.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	live := event("live")
+	_ = log.SaveIntegrationEvent(live)
+	_ = bus.PublishEvents([]cqrs.VersionedEvent{live})
+
+	deadline := time.Now().Add(time.Second)
+	for runner.Checkpoint().Position < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := projection.Messages(); len(messages) != 2 || messages[0] != "stored" || messages[1] != "live" {
+		t.Fatal("Expected each event to be projected once in order but got", messages)
+	}
+
+	// Events stored while the projection was stopped are caught up from the persisted checkpoint
+	_ = log.SaveIntegrationEvent(event("missed"))
+	restarted := &MessageCountProjection{}
+	runner = cqrs.NewProjectionRunner(restarted, log, receiver, checkpoints, cqrs.NewTypeRegistry())
+	if err := runner.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Stop()
+
+	if messages := restarted.Messages(); len(messages) != 1 || messages[0] != "missed" {
+		t.Fatal("Expected only the missed event to be caught up but got", messages)
+	}
+
+	if checkpoint := runner.Checkpoint(); checkpoint.Position != 3 || checkpoint.EventID != "missed" {
+		t.Fatal("Expected the checkpoint to point at the missed event but got", checkpoint)
+	}
+}
+
+// FailingOnceProjection fails the first time it handles the event with the given ID
+type FailingOnceProjection struct {
+	MessageCountProjection
+	failID string
+	failed bool
+}
+
+func (p *FailingOnceProjection) Handle(event cqrs.VersionedEvent) error {
+	if event.ID == p.failID && !p.failed {
+		p.failed = true
+		return errors.New("projection failed")
+	}
+
+	return p.MessageCountProjection.Handle(event)
+}
+
+func TestProjectionRunnerCheckpointStopsAtFailedLiveEvent(t *testing.T) {
+	log := cqrs.NewInMemoryEventStreamRepository()
+	bus := cqrs.NewInMemoryEventBus()
+	checkpoints := cqrs.NewInMemoryCheckpointStore()
+
+	projection := &FailingOnceProjection{failID: "failing"}
+	runner := cqrs.NewProjectionRunner(projection, log, bus.Subscriber("projections"), checkpoints, cqrs.NewTypeRegistry())
+	if err := runner.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"first", "failing", "last"} {
+		event := cqrs.VersionedEvent{ID: id, Created: time.Now(), Event: SampleMessageReceivedEvent{id}}
+		_ = log.SaveIntegrationEvent(event)
+		_ = bus.PublishEvents([]cqrs.VersionedEvent{event})
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(projection.Messages()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if checkpoint, _ := checkpoints.GetCheckpoint(projection.Name()); checkpoint.Position != 1 || checkpoint.EventID != "first" {
+		t.Fatal("Expected the checkpoint to stop before the failed event but got", checkpoint)
+	}
+
+	// The restarted projection resumes from the failed event
+	restarted := &MessageCountProjection{}
+	runner = cqrs.NewProjectionRunner(restarted, log, bus.Subscriber("projections"), checkpoints, cqrs.NewTypeRegistry())
+	if err := runner.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Stop()
+
+	if messages := restarted.Messages(); len(messages) != 2 || messages[0] != "failing" || messages[1] != "last" {
+		t.Fatal("Expected the failed event to be handled again after restarting but got", messages)
+	}
+}
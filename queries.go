@@ -0,0 +1,272 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrQueryHandlerAlreadyRegistered is returned when a second handler is registered for a query type
+var ErrQueryHandlerAlreadyRegistered = errors.New("query handler already registered")
+
+// ErrUnhandledQuery is returned when no handler is registered for a query type
+var ErrUnhandledQuery = errors.New("no query handler registered")
+
+// ErrQueryTimeout is returned when a sent query's result is not received in time
+var ErrQueryTimeout = errors.New("timeout waiting for query result")
+
+// ErrQueryFailed is returned by QueryResult.Err for failed queries whose result carries no error message
+var ErrQueryFailed = errors.New("query failed")
+
+// DefaultQueryTimeout is the default duration a query sender waits for a query result
+var DefaultQueryTimeout = 30 * time.Second
+
+// Query represents an actor request to read the state of the system
+type Query struct {
+	MessageID     string    `json:"messageID"`
+	CorrelationID string    `json:"correlationID"`
+	QueryType     string    `json:"queryType"`
+	Actor         string    `json:"actor"`
+	OnBehalfOf    string    `json:"onbehalfof"`
+	Created       time.Time `json:"time"`
+	Body          interface{}
+}
+
+// CreateQuery is a helper for creating a new query object with populated default properties
+func CreateQuery(body interface{}) Query {
+	queryType := reflect.TypeOf(body)
+	return Query{MessageID: "mid:" + NewUUIDString(),
+		CorrelationID: "cid:" + NewUUIDString(),
+		QueryType:     queryType.String(),
+		Created:       time.Now(),
+
+		Body: body}
+}
+
+// QueryResult represents the outcome of a query after it has been processed by its query handler
+type QueryResult struct {
+	MessageID     string          `json:"messageID"`
+	CorrelationID string          `json:"correlationID"`
+	Success       bool            `json:"success"`
+	Error         string          `json:"error,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+}
+
+// NewQueryResult is a helper for creating the result of processing the given query. The result is encoded as JSON
+func NewQueryResult(query Query, result interface{}, err error) QueryResult {
+	queryResult := QueryResult{MessageID: query.MessageID, CorrelationID: query.CorrelationID, Success: err == nil}
+	if err != nil {
+		queryResult.Error = err.Error()
+		return queryResult
+	}
+
+	encodedResult, err := json.Marshal(result)
+	if err != nil {
+		queryResult.Success = false
+		queryResult.Error = fmt.Sprintf("json.Marshal query result: %v", err)
+		return queryResult
+	}
+
+	queryResult.Result = encodedResult
+	return queryResult
+}
+
+// Err returns the error reported by the query handler or nil if the query was processed successfully
+func (r QueryResult) Err() error {
+	if r.Success {
+		return nil
+	}
+
+	if len(r.Error) == 0 {
+		return fmt.Errorf("%w: %s", ErrQueryFailed, r.MessageID)
+	}
+
+	return errors.New(r.Error)
+}
+
+// Decode decodes the result of a successful query into the value pointed to by target
+func (r QueryResult) Decode(target interface{}) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(r.Result, target); err != nil {
+		return fmt.Errorf("json.Unmarshal query result: %v", err)
+	}
+
+	return nil
+}
+
+// QueryHandler is a function that takes a query and returns its result
+type QueryHandler func(Query) (interface{}, error)
+
+// QuerySender is responsible for sending a query and waiting for its result
+type QuerySender interface {
+	SendQuery(Query) (QueryResult, error)
+}
+
+// QueryReceiver is responsible for receiving queries
+type QueryReceiver interface {
+	ReceiveQueries(QueryReceiverOptions) error
+}
+
+// QueryBus ...
+type QueryBus interface {
+	QuerySender
+	QueryReceiver
+}
+
+// QueryReceiverOptions is an initalization structure to communicate to and from a query receiver go routine
+type QueryReceiverOptions struct {
+	TypeRegistry  TypeRegistry
+	Close         chan chan error
+	Error         chan error
+	ReceiveQuery  QueryHandler
+	Exclusive     bool
+	ListenerCount int
+}
+
+// QueryDispatcher is responsible for routing queries from the query manager to the handlers responsible for answering them
+type QueryDispatcher interface {
+	DispatchQuery(Query) (interface{}, error)
+	RegisterQueryHandler(query interface{}, handler QueryHandler) (Subscription, error)
+}
+
+// MapBasedQueryDispatcher is a simple implementation of the query dispatcher. Using a map it registers a single query handler per query type
+type MapBasedQueryDispatcher struct {
+	lock          sync.RWMutex
+	registry      map[reflect.Type]queryHandlerEntry
+	nextHandlerID int
+}
+
+type queryHandlerEntry struct {
+	id      int
+	handler QueryHandler
+}
+
+// NewMapBasedQueryDispatcher is a constructor for the MapBasedQueryDispatcher
+func NewMapBasedQueryDispatcher() *MapBasedQueryDispatcher {
+	return &MapBasedQueryDispatcher{registry: make(map[reflect.Type]queryHandlerEntry)}
+}
+
+// RegisterQueryHandler allows a caller to register the query handler answering queries of the specified type.
+// Registering a second query handler for the same query type returns ErrQueryHandlerAlreadyRegistered.
+// The returned subscription removes the handler again
+func (m *MapBasedQueryDispatcher) RegisterQueryHandler(query interface{}, handler QueryHandler) (Subscription, error) {
+	queryType := reflect.TypeOf(query)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.registry[queryType]; ok {
+		return nil, fmt.Errorf("%w for %s", ErrQueryHandlerAlreadyRegistered, queryType)
+	}
+
+	m.nextHandlerID++
+	entry := queryHandlerEntry{m.nextHandlerID, handler}
+	m.registry[queryType] = entry
+
+	return newSubscription(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if registered, ok := m.registry[queryType]; ok && registered.id == entry.id {
+			delete(m.registry, queryType)
+		}
+	}), nil
+}
+
+// DispatchQuery executes the query handler registered for the given query type
+func (m *MapBasedQueryDispatcher) DispatchQuery(query Query) (interface{}, error) {
+	m.lock.RLock()
+	entry, ok := m.registry[reflect.TypeOf(query.Body)]
+	m.lock.RUnlock()
+
+	if !ok {
+		metricsQueriesFailed.WithLabelValues(query.QueryType).Inc()
+		return nil, fmt.Errorf("%w for %s", ErrUnhandledQuery, query.QueryType)
+	}
+
+	result, err := entry.handler(query)
+	if err != nil {
+		metricsQueriesFailed.WithLabelValues(query.QueryType).Inc()
+		return nil, &HandlerError{handlerName(entry.handler), err}
+	}
+
+	metricsQueriesDispatched.WithLabelValues(query.QueryType).Inc()
+
+	return result, nil
+}
+
+// QueryDispatchManager is responsible for coordinating receiving queries from query receivers and dispatching them to the query dispatcher.
+type QueryDispatchManager struct {
+	queryDispatcher *MapBasedQueryDispatcher
+	typeRegistry    TypeRegistry
+	receiver        QueryReceiver
+	middleware      []QueryMiddleware
+	observers       []ReceiverErrorObserver
+}
+
+// NewQueryDispatchManager is a constructor for the QueryDispatchManager
+func NewQueryDispatchManager(receiver QueryReceiver, registry TypeRegistry) *QueryDispatchManager {
+	return &QueryDispatchManager{queryDispatcher: NewMapBasedQueryDispatcher(), typeRegistry: registry, receiver: receiver}
+}
+
+// QueryDispatcher the internal query dispatcher
+func (m *QueryDispatchManager) QueryDispatcher() QueryDispatcher {
+	return m.queryDispatcher
+}
+
+// Use appends middleware wrapping the dispatch of every received query. The first middleware registered is the outermost.
+// Middleware must be registered before calling Listen
+func (m *QueryDispatchManager) Use(middleware ...QueryMiddleware) {
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// OnError registers an observer called with every error reported while receiving and dispatching queries.
+// Observers must be registered before calling Listen
+func (m *QueryDispatchManager) OnError(observer ReceiverErrorObserver) {
+	m.observers = append(m.observers, observer)
+}
+
+// RegisterQueryHandler allows a caller to register the query handler answering queries of the specified type.
+// The returned subscription removes the handler again
+func (m *QueryDispatchManager) RegisterQueryHandler(query interface{}, handler QueryHandler) (Subscription, error) {
+	m.typeRegistry.RegisterType(query)
+	return m.queryDispatcher.RegisterQueryHandler(query, handler)
+}
+
+// Listen starts a listen loop processing channels related to new incoming queries, errors and stop listening requests.
+// Signalling stop or calling Shutdown on the returned listener stops receiving queries
+func (m *QueryDispatchManager) Listen(stop <-chan bool, exclusive bool, listenerCount int) (*Listener, error) {
+	// Create communication channels
+	//
+	// for closing the queue listener,
+	closeChannel := make(chan chan error)
+	// receiving errors from the listener thread (go routine)
+	errorChannel := make(chan error)
+	listener := newListener("QueryDispatchManager", closeChannel, errorChannel, listenerCount, m.observers)
+
+	dispatchQuery := ChainQueryMiddleware(m.queryDispatcher.DispatchQuery, m.middleware...)
+	receiveQueryHandler := func(query Query) (result interface{}, err error) {
+		PackageLogger().Debugf("QueryDispatchManager.DispatchQuery: %v", query.CorrelationID)
+		listener.track(func() { result, err = dispatchQuery(query) })
+		if err != nil {
+			PackageLogger().Debugf("Error dispatching query: %v", err)
+			listener.reportError(&ReceiverError{ReceiverErrorHandler, query.QueryType, query.MessageID, err})
+		}
+
+		return result, err
+	}
+
+	// Start receiving queries by passing these channels to the worker thread (go routine)
+	options := QueryReceiverOptions{m.typeRegistry, closeChannel, errorChannel, receiveQueryHandler, exclusive, listenerCount}
+	if err := m.receiver.ReceiveQueries(options); err != nil {
+		return nil, err
+	}
+
+	go listener.run(stop)
+
+	return listener, nil
+}
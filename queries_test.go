@@ -0,0 +1,86 @@
+package cqrs_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type GetLedgerBalanceQuery struct {
+	LedgerID string
+}
+
+type LedgerBalance struct {
+	LedgerID string
+	Balance  float64
+}
+
+type UnknownLedgerQuery struct{}
+
+func TestQueryDispatchManager(t *testing.T) {
+	bus := cqrs.NewInMemoryQueryBus()
+	manager := cqrs.NewQueryDispatchManager(bus, cqrs.NewTypeRegistry())
+	manager.Use(cqrs.QueryRecoveryMiddleware())
+
+	handler := func(query cqrs.Query) (interface{}, error) {
+		ledgerID := query.Body.(GetLedgerBalanceQuery).LedgerID
+		if ledgerID == "missing" {
+			return nil, errors.New("ledger not found")
+		}
+
+		return LedgerBalance{ledgerID, 42}, nil
+	}
+
+	if _, err := manager.RegisterQueryHandler(GetLedgerBalanceQuery{}, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manager.RegisterQueryHandler(GetLedgerBalanceQuery{}, handler); !errors.Is(err, cqrs.ErrQueryHandlerAlreadyRegistered) {
+		t.Fatal("Expected a second query handler registration to fail but got", err)
+	}
+
+	listener, err := manager.Listen(nil, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Shutdown(context.Background())
+
+	result, err := bus.SendQuery(cqrs.CreateQuery(GetLedgerBalanceQuery{"ledger-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var balance LedgerBalance
+	if err := result.Decode(&balance); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.LedgerID != "ledger-1" || balance.Balance != 42 {
+		t.Fatal("Expected the query result to be decoded but got", balance)
+	}
+
+	result, err = bus.SendQuery(cqrs.CreateQuery(GetLedgerBalanceQuery{"missing"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := result.Decode(&balance); err == nil || !strings.Contains(err.Error(), "ledger not found") {
+		t.Fatal("Expected the query handler error to be returned but got", err)
+	}
+
+	result, err = bus.SendQuery(cqrs.CreateQuery(UnknownLedgerQuery{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Success {
+		t.Fatal("Expected a query without handler to fail")
+	}
+
+	if err := (cqrs.QueryResult{MessageID: "mid:1"}).Err(); !errors.Is(err, cqrs.ErrQueryFailed) || err.Error() != "query failed: mid:1" {
+		t.Fatal("Expected a failed query without error message to return ErrQueryFailed but got", err)
+	}
+}
@@ -5,8 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
-	"sync"
-	"sync/atomic"
+	"strings"
 	"time"
 
 	"github.com/andrewwebber/cqrs"
@@ -19,253 +18,267 @@ type RawCommand struct {
 	MessageID     string    `json:"messageID"`
 	CorrelationID string    `json:"correlationID"`
 	CommandType   string    `json:"commandType"`
+	Actor         string    `json:"actor"`
+	OnBehalfOf    string    `json:"onbehalfof"`
 	Created       time.Time `json:"time"`
 	Body          json.RawMessage
 }
 
 // CommandBus ...
 type CommandBus struct {
-	resolver          ConnectionStringResolver
-	name              string
-	exchange          string
-	channel           *amqp.Channel
-	reconnect         chan reconnectionAttempt
-	conn              *amqp.Connection
-	reconnectContext  int
-	healthyconnection uint32
+	busConnection
+	name            string
+	exchange        string
+	retryPolicy     *RetryPolicy
+	commandTimeout  time.Duration
+	replies         replies
+	routeByType     bool
+	bindings        []string
+	orderedWorkers  int
+	orderingKeyFunc func(cqrs.Command) string
 }
 
 // NewCommandBus will create a new command bus
 func NewCommandBus(resolver ConnectionStringResolver, name string, exchange string) *CommandBus {
-	bus := &CommandBus{resolver: resolver, name: name, exchange: exchange, healthyconnection: 1}
-	reconnectCh := initializeReconnectionManagement(resolver, func(conn *amqp.Connection, ctx int) {
-		bus.conn = conn
-		bus.reconnectContext = ctx
-		_ = bus.connect(bus.conn)
-	})
-	respCh := make(chan reconnectionAttemptResponse)
-	reconnectCh <- reconnectionAttempt{context: 0, response: respCh}
-	<-respCh
-	bus.reconnect = reconnectCh
+	bus := &CommandBus{name: name, exchange: exchange, commandTimeout: cqrs.DefaultCommandTimeout}
+	bus.open(resolver, declareExchange(exchange, topicExchange))
 
 	return bus
 }
 
-func (bus *CommandBus) getConnectionString() (string, error) {
-	var connectionString string
-	retryError := exponential(func() error {
-		result, err := bus.resolver()
-		if err != nil {
-			return err
-		}
+// SetCommandTimeout sets the duration SendCommand waits for a command result
+func (bus *CommandBus) SetCommandTimeout(timeout time.Duration) {
+	bus.commandTimeout = timeout
+}
 
-		connectionString = result
-		return err
-	}, 5)
+// SetCommandTypeRouting publishes commands with a routing key derived from their command type and binds the command queue
+// to the given binding patterns, allowing services to own different commands on a shared exchange and hot command types to
+// be consumed from dedicated queues. Without binding patterns the queue receives every command.
+// Routing must be configured before publishing or receiving commands
+func (bus *CommandBus) SetCommandTypeRouting(bindings ...string) {
+	bus.routeByType = true
+	bus.bindings = bindings
+	if len(bus.bindings) == 0 {
+		bus.bindings = []string{"#"}
+	}
+}
 
-	return connectionString, retryError
+// CommandTypeRoutingKey returns the routing key for commands of the given type, suitable as a binding pattern
+func CommandTypeRoutingKey(command interface{}) string {
+	return typeRoutingKey(reflect.TypeOf(command).String())
 }
 
-// PublishCommands will publish commands
-func (bus *CommandBus) PublishCommands(commands []cqrs.Command) error {
+// typeRoutingKey derives a routing key from a type name, dropping the pointer prefix
+func typeRoutingKey(typeName string) string {
+	return strings.TrimLeft(typeName, "*")
+}
 
-	for _, command := range commands {
-		encodedCommand, err := json.Marshal(command)
-		if err != nil {
-			return fmt.Errorf("json.Marshal: %v", err)
-		}
+func (bus *CommandBus) routingKey(command cqrs.Command) string {
+	if bus.routeByType {
+		return typeRoutingKey(command.CommandType)
+	}
 
-		// Prepare this message to be persistent.  Your publishing requirements may
-		// be different.
-		msg := amqp.Publishing{
-			DeliveryMode:    amqp.Persistent,
-			Timestamp:       time.Now().UTC(),
-			ContentEncoding: "UTF-8",
-			ContentType:     "text/plain",
-			Body:            encodedCommand,
-		}
+	return bus.name
+}
 
-		retryError := exponential(func() error {
-			err = bus.channel.Publish(bus.exchange, bus.name, true, false, msg)
-
-			if err != nil {
-				atomic.CompareAndSwapUint32(&bus.healthyconnection, 1, 0)
-				respCh := make(chan reconnectionAttemptResponse)
-				bus.reconnect <- reconnectionAttempt{context: bus.reconnectContext, response: respCh}
-				resp := <-respCh
-				bus.conn = resp.connection
-				bus.reconnectContext = resp.newContext
-
-				connErr := bus.connect(bus.conn)
-				if connErr == nil {
-					cqrs.PackageLogger().Debugf("RabbitMQ: Reconnected")
-					atomic.CompareAndSwapUint32(&bus.healthyconnection, 0, 1)
-				} else {
-					cqrs.PackageLogger().Debugf("RabbitMQ: Reconnect Failed %v", err)
-				}
-			}
+// queueBindings returns the binding patterns of the command queue
+func (bus *CommandBus) queueBindings() []string {
+	if bus.routeByType {
+		return bus.bindings
+	}
 
-			return err
-		}, 3)
+	return []string{bus.name}
+}
 
-		if retryError != nil {
-			metricsCommandsFailed.WithLabelValues(command.CommandType).Inc()
-			return fmt.Errorf("bus.publish: %v", err)
+// staleBindings returns the binding patterns to remove from a command queue declared before type routing was configured.
+// Left in place, the binding to the queue name keeps delivering commands the queue is no longer bound to by type
+func (bus *CommandBus) staleBindings() []string {
+	if !bus.routeByType {
+		return nil
+	}
+
+	for _, binding := range bus.bindings {
+		if binding == bus.name {
+			return nil
 		}
+	}
 
-		metricsCommandsPublished.WithLabelValues(command.CommandType).Inc()
+	return []string{bus.name}
+}
+
+// PublishCommands will publish commands
+func (bus *CommandBus) PublishCommands(commands []cqrs.Command) error {
+	for _, command := range commands {
+		if err := bus.publishCommand(command, ""); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (bus *CommandBus) connect(conn *amqp.Connection) error {
-	// This waits for a server acknowledgment which means the sockets will have
-	// flushed all outbound publishings prior to returning.  It's important to
-	// block on Close to not lose any publishings.
-	//defer conn.Close()
-
-	c, err := conn.Channel()
+// SendCommand will publish a command and wait for the result of its command handlers.
+// Results are returned through an exclusive reply queue and matched to the command using its message ID.
+// With a retry policy the result is only returned once the command succeeded or was dead lettered, so the command timeout must cover the retries
+func (bus *CommandBus) SendCommand(command cqrs.Command) (cqrs.CommandResult, error) {
+	replies, err := bus.replies.get(bus.conn)
 	if err != nil {
-		return fmt.Errorf("channel.open: %s", err)
+		return cqrs.CommandResult{}, err
 	}
 
-	// We declare our topology on both the publisher and consumer to ensure they
-	// are the same.  This is part of AMQP being a programmable messaging model.
-	//
-	// See the Channel.Consume example for the complimentary declare.
-	err = c.ExchangeDeclare(bus.exchange, "topic", true, false, false, false, nil)
+	var result cqrs.CommandResult
+	publish := func(replyTo string) error {
+		return bus.publishCommand(command, replyTo)
+	}
+
+	if err := replies.request(command.MessageID, publish, bus.commandTimeout, cqrs.ErrCommandTimeout, &result); err != nil {
+		return cqrs.CommandResult{}, err
+	}
+
+	return result, nil
+}
+
+func (bus *CommandBus) publishCommand(command cqrs.Command, replyTo string) error {
+	encodedCommand, err := json.Marshal(command)
 	if err != nil {
-		return fmt.Errorf("exchange.declare: %v", err)
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+
+	// Prepare this message to be persistent.  Your publishing requirements may
+	// be different.
+	msg := amqp.Publishing{
+		DeliveryMode:    amqp.Persistent,
+		Timestamp:       time.Now().UTC(),
+		ContentEncoding: "UTF-8",
+		ContentType:     "text/plain",
+		Body:            encodedCommand,
+	}
+
+	if len(replyTo) > 0 {
+		msg.ReplyTo = replyTo
+		msg.CorrelationId = command.MessageID
+	}
+
+	if err := bus.publish(bus.exchange, bus.routingKey(command), msg); err != nil {
+		metricsCommandsFailed.WithLabelValues(command.CommandType).Inc()
+		return err
 	}
 
-	bus.channel = c
+	metricsCommandsPublished.WithLabelValues(command.CommandType).Inc()
 
 	return nil
 }
 
+// SetRetryPolicy configures bounded retries and dead lettering of commands whose handlers fail.
+// Without a retry policy failed commands are requeued. The policy must be set before receiving commands
+func (bus *CommandBus) SetRetryPolicy(policy RetryPolicy) {
+	policy = policy.withDefaults()
+	bus.retryPolicy = &policy
+}
+
+// SetOrderedDelivery handles received commands on a fixed number of worker go routines partitioned by key.
+// Commands sharing a key are handled one at a time in the order they were delivered, while commands with different keys are
+// handled in parallel. The key defaults to the command's CorrelationID when key is nil.
+// Order is only guaranteed for deliveries received by the same listener, and commands that are retried are redelivered out of order.
+// Ordered delivery must be configured before receiving commands
+func (bus *CommandBus) SetOrderedDelivery(workers int, key func(cqrs.Command) string) {
+	bus.orderedWorkers = workers
+	bus.orderingKeyFunc = key
+}
+
 // ReceiveCommands will recieve commands
 func (bus *CommandBus) ReceiveCommands(options cqrs.CommandReceiverOptions) error {
-	conn := bus.conn
-
-	listenerStart := time.Now()
-	var wg sync.WaitGroup
-	for n := 0; n < options.ListenerCount; n++ {
-		wg.Add(1)
-		go func(reconnectionChannel chan<- reconnectionAttempt) {
-			reconnectionContext := 0
-			c, commands, err := bus.consumeCommandsQueue(conn, options.Exclusive)
-			if err != nil {
-				return
+	receiver := &deliveryReceiver{
+		name:              "commands",
+		conn:              bus.conn,
+		reconnect:         bus.reconnect,
+		healthyconnection: &bus.healthyconnection,
+		orderedWorkers:    bus.orderedWorkers,
+		close:             options.Close,
+		errors:            options.Error,
+		consume: func(conn *amqp.Connection) (*consumer, error) {
+			return bus.consumeCommandsQueue(conn, options.Exclusive)
+		},
+		prepare: func(c *consumer, message amqp.Delivery) (func(), string, bool) {
+			command, ok := bus.decodeDelivery(message, options)
+			if !ok {
+				return nil, "", false
 			}
 
-			wg.Done()
+			return func() { bus.handleCommand(c, message, command, options) }, bus.orderingKey(command), true
+		},
+	}
 
-			notifyClose := conn.NotifyClose(make(chan *amqp.Error))
-			reconnect := func() {
-				atomic.CompareAndSwapUint32(&bus.healthyconnection, 1, 0)
-				respCh := make(chan reconnectionAttemptResponse)
-				reconnectionChannel <- reconnectionAttempt{context: reconnectionContext, response: respCh}
-				resp := <-respCh
-				conn = resp.connection
-				reconnectionContext = resp.newContext
+	return receiver.start(options.ListenerCount)
+}
 
-				cR, commandsR, errR := bus.consumeCommandsQueue(conn, options.Exclusive)
-				if errR == nil {
-					c, commands, _ = cR, commandsR, errR
-				}
+// decodeDelivery deserializes the command carried by a delivery
+func (bus *CommandBus) decodeDelivery(message amqp.Delivery, options cqrs.CommandReceiverOptions) (cqrs.Command, bool) {
+	var raw RawCommand
+	if errUnmarshalRaw := json.Unmarshal(message.Body, &raw); errUnmarshalRaw != nil {
+		options.Error <- cqrs.NewReceiverError(cqrs.ReceiverErrorDeserialization, "", fmt.Errorf("json.Unmarshal received command: %v", errUnmarshalRaw))
+		return cqrs.Command{}, false
+	}
 
-				notifyClose = conn.NotifyClose(make(chan *amqp.Error))
-				atomic.CompareAndSwapUint32(&bus.healthyconnection, 0, 1)
-			}
+	commandType, ok := options.TypeRegistry.GetTypeByName(raw.CommandType)
+	if !ok {
+		cqrs.PackageLogger().Debugf("CommandBus.Cannot find command type", raw.CommandType)
+		options.Error <- cqrs.NewReceiverError(cqrs.ReceiverErrorUnknownType, raw.CommandType, errors.New("Cannot find command type "+raw.CommandType))
+		return cqrs.Command{}, false
+	}
 
-			for {
-				select {
-				case ch := <-options.Close:
-					cqrs.PackageLogger().Debugf("Close requested")
-					defer closeConnection(conn)
-					ch <- c.Cancel(bus.name, false)
-					return
-
-				case <-notifyClose:
-					reconnect()
-
-				case m, more := <-commands:
-					if more {
-						go func(message amqp.Delivery) {
-							var raw RawCommand
-							if errUnmarshalRaw := json.Unmarshal(message.Body, &raw); errUnmarshalRaw != nil {
-								options.Error <- fmt.Errorf("json.Unmarshal received command: %v", errUnmarshalRaw)
-							} else {
-								commandType, ok := options.TypeRegistry.GetTypeByName(raw.CommandType)
-								if !ok {
-									cqrs.PackageLogger().Debugf("CommandBus.Cannot find command type", raw.CommandType)
-									options.Error <- errors.New("Cannot find command type " + raw.CommandType)
-								} else {
-									commandValue := reflect.New(commandType)
-									commandBody := commandValue.Interface()
-									if errUnmarshalBody := json.Unmarshal(raw.Body, commandBody); errUnmarshalBody != nil {
-										options.Error <- errors.New("Error deserializing command " + raw.CommandType)
-									} else {
-										command := cqrs.Command{
-											MessageID:     raw.MessageID,
-											CorrelationID: raw.CorrelationID,
-											CommandType:   raw.CommandType,
-											Created:       raw.Created,
-
-											Body: reflect.Indirect(commandValue).Interface()}
-
-										start := time.Now()
-										execErr := options.ReceiveCommand(command)
-										result := execErr == nil
-										if result {
-											err = message.Ack(false)
-											if err != nil {
-												cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
-											}
-											elapsed := time.Since(start)
-											// stats := map[string]string{
-											// 	"CQRS_LOG":      "true",
-											// 	"CQRS_DURATION": fmt.Sprintf("%s", elapsed),
-											// 	"CQRS_TYPE":     raw.CommandType,
-											// 	"CQRS_CREATED":  fmt.Sprintf("%s", raw.Created),
-											// 	"CQRS_CORR":     raw.CorrelationID}
-											cqrs.PackageLogger().Debugf(fmt.Sprintf("CommandBus Message Took %s", elapsed))
-										} else {
-											err = message.Reject(true)
-											if err != nil {
-												cqrs.PackageLogger().Debugf("ERROR: Message reject returned error: %v\n", err)
-											}
-										}
-									}
-								}
-							}
-						}(m)
-					} else {
-						c, commands, _ = bus.consumeCommandsQueue(conn, options.Exclusive)
-						for err != nil {
-							reconnect()
-							c, commands, _ = bus.consumeCommandsQueue(conn, options.Exclusive)
-							<-time.After(1 * time.Second)
-						}
-					}
-				}
-			}
-		}(bus.reconnect)
+	commandValue := reflect.New(commandType)
+	if errUnmarshalBody := json.Unmarshal(raw.Body, commandValue.Interface()); errUnmarshalBody != nil {
+		options.Error <- cqrs.NewReceiverError(cqrs.ReceiverErrorDeserialization, raw.CommandType, fmt.Errorf("Error deserializing command %s: %v", raw.CommandType, errUnmarshalBody))
+		return cqrs.Command{}, false
 	}
 
-	wg.Wait()
-	listenerElapsed := time.Since(listenerStart)
-	cqrs.PackageLogger().Debugf("Receiving commands - %s", listenerElapsed)
+	return cqrs.Command{
+		MessageID:     raw.MessageID,
+		CorrelationID: raw.CorrelationID,
+		CommandType:   raw.CommandType,
+		Actor:         raw.Actor,
+		OnBehalfOf:    raw.OnBehalfOf,
+		Created:       raw.Created,
 
-	return nil
+		Body: reflect.Indirect(commandValue).Interface()}, true
+}
+
+// handleCommand passes a command to the receiver and acknowledges or rejects its delivery.
+// The sender is only replied to with the final outcome, so commands that are retried are answered once their last attempt completed
+func (bus *CommandBus) handleCommand(c *consumer, message amqp.Delivery, command cqrs.Command, options cqrs.CommandReceiverOptions) {
+	start := time.Now()
+	execErr := options.ReceiveCommand(command)
+	if execErr != nil {
+		if rejectDelivery(c, message, bus.exchange, bus.name, bus.retryPolicy, cqrs.NewCommandDeadLetter(command, execErr)) {
+			replyJSON(c.channel, message, cqrs.NewCommandResult(command, execErr))
+		}
+
+		return
+	}
+
+	if err := message.Ack(false); err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
+	}
+
+	replyJSON(c.channel, message, cqrs.NewCommandResult(command, nil))
+
+	cqrs.PackageLogger().Debugf("CommandBus Message Took %s", time.Since(start))
 }
 
-func (bus *CommandBus) consumeCommandsQueue(conn *amqp.Connection, exclusive bool) (*amqp.Channel, <-chan amqp.Delivery, error) {
+func (bus *CommandBus) orderingKey(command cqrs.Command) string {
+	if bus.orderingKeyFunc != nil {
+		return bus.orderingKeyFunc(command)
+	}
+
+	return command.CorrelationID
+}
+
+func (bus *CommandBus) consumeCommandsQueue(conn *amqp.Connection, exclusive bool) (*consumer, error) {
 
 	c, err := conn.Channel()
 	if err != nil {
-		return nil, nil, fmt.Errorf("channel.open: %s", err)
+		return nil, fmt.Errorf("channel.open: %s", err)
 	}
 
 	// We declare our topology on both the publisher and consumer to ensure they
@@ -274,32 +287,47 @@ func (bus *CommandBus) consumeCommandsQueue(conn *amqp.Connection, exclusive boo
 	// See the Channel.Consume example for the complimentary declare.
 	err = c.ExchangeDeclare(bus.exchange, "topic", true, false, false, false, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("exchange.declare: %v", err)
+		return nil, fmt.Errorf("exchange.declare: %v", err)
 	}
 
 	if _, err = c.QueueDeclare(bus.name, true, false, false, false, nil); err != nil {
-		return nil, nil, fmt.Errorf("queue.declare: %v", err)
+		return nil, fmt.Errorf("queue.declare: %v", err)
 	}
 
-	if err = c.QueueBind(bus.name, bus.name, bus.exchange, false, nil); err != nil {
-		return nil, nil, fmt.Errorf("queue.bind: %v", err)
+	for _, binding := range bus.queueBindings() {
+		if err = c.QueueBind(bus.name, binding, bus.exchange, false, nil); err != nil {
+			return nil, fmt.Errorf("queue.bind: %v", err)
+		}
 	}
 
-	commands, err := c.Consume(bus.name, "", false, exclusive, false, false, nil)
-	if err != nil {
-		return nil, nil, fmt.Errorf("basic.consume: %v", err)
+	for _, binding := range bus.staleBindings() {
+		if err = c.QueueUnbind(bus.name, binding, bus.exchange, nil); err != nil {
+			return nil, fmt.Errorf("queue.unbind: %v", err)
+		}
 	}
 
-	if err := c.Qos(Prefetch, 0, false); err != nil {
-		return nil, nil, fmt.Errorf("Qos: %v", err)
+	if bus.retryPolicy != nil {
+		if err = declareRetryTopology(c, bus.exchange, bus.name, bus.retryPolicy); err != nil {
+			return nil, err
+		}
 	}
 
-	return c, commands, nil
-}
+	if err := c.Qos(Prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("Qos: %v", err)
+	}
 
-func closeConnection(conn *amqp.Connection) {
-	err := conn.Close()
+	tag := consumerTag(bus.name)
+	commands, err := c.Consume(bus.name, tag, false, exclusive, false, false, nil)
 	if err != nil {
-		cqrs.PackageLogger().Debugf("Couldn't close conn: %v\n", err)
+		return nil, fmt.Errorf("basic.consume: %v", err)
 	}
+
+	receiver := &consumer{channel: c, tag: tag, deliveries: commands}
+	if bus.retryPolicy != nil {
+		if receiver.retries, err = newConfirmedPublisher(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	return receiver, nil
 }
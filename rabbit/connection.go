@@ -0,0 +1,125 @@
+package rabbit
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/andrewwebber/cqrs"
+
+	"github.com/streadway/amqp"
+)
+
+// busConnection holds the broker connection shared by the command, event and query buses and the channel they publish on.
+// Publishing reconnects and retries when the connection was lost
+type busConnection struct {
+	resolver          ConnectionStringResolver
+	channel           *amqp.Channel
+	reconnect         chan reconnectionAttempt
+	conn              *amqp.Connection
+	reconnectContext  int
+	healthyconnection uint32
+	declare           func(*amqp.Channel) error
+}
+
+// open connects to the broker and declares the topology of the bus on its publishing channel, redeclaring it after reconnecting
+func (b *busConnection) open(resolver ConnectionStringResolver, declare func(*amqp.Channel) error) {
+	b.resolver = resolver
+	b.declare = declare
+	b.healthyconnection = 1
+	reconnectCh := initializeReconnectionManagement(resolver, func(conn *amqp.Connection, ctx int) {
+		b.conn = conn
+		b.reconnectContext = ctx
+		_ = b.connect(b.conn)
+	})
+	respCh := make(chan reconnectionAttemptResponse)
+	reconnectCh <- reconnectionAttempt{context: 0, response: respCh}
+	<-respCh
+	b.reconnect = reconnectCh
+}
+
+func (b *busConnection) getConnectionString() (string, error) {
+	var connectionString string
+	retryError := exponential(func() error {
+		result, err := b.resolver()
+		if err != nil {
+			return err
+		}
+
+		connectionString = result
+		return err
+	}, 5)
+
+	return connectionString, retryError
+}
+
+func (b *busConnection) connect(conn *amqp.Connection) error {
+	// This waits for a server acknowledgment which means the sockets will have
+	// flushed all outbound publishings prior to returning.  It's important to
+	// block on Close to not lose any publishings.
+	//defer conn.Close()
+
+	c, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("channel.open: %s", err)
+	}
+
+	// We declare our topology on both the publisher and consumer to ensure they
+	// are the same.  This is part of AMQP being a programmable messaging model.
+	//
+	// See the Channel.Consume example for the complimentary declare.
+	if err = b.declare(c); err != nil {
+		return err
+	}
+
+	b.channel = c
+
+	return nil
+}
+
+// publish publishes a message, reconnecting and retrying when publishing fails
+func (b *busConnection) publish(exchange string, key string, msg amqp.Publishing) error {
+	var err error
+	retryError := exponential(func() error {
+		err = b.channel.Publish(exchange, key, true, false, msg)
+
+		if err != nil {
+			atomic.CompareAndSwapUint32(&b.healthyconnection, 1, 0)
+			respCh := make(chan reconnectionAttemptResponse)
+			b.reconnect <- reconnectionAttempt{context: b.reconnectContext, response: respCh}
+			resp := <-respCh
+			b.conn = resp.connection
+			b.reconnectContext = resp.newContext
+
+			connErr := b.connect(b.conn)
+			if connErr == nil {
+				cqrs.PackageLogger().Debugf("RabbitMQ: Reconnected")
+				atomic.CompareAndSwapUint32(&b.healthyconnection, 0, 1)
+			} else {
+				cqrs.PackageLogger().Debugf("RabbitMQ: Reconnect Failed %v", err)
+			}
+		}
+
+		return err
+	}, 3)
+
+	if retryError != nil {
+		return fmt.Errorf("bus.publish: %v", err)
+	}
+
+	return nil
+}
+
+// declareExchange returns a topology declaration declaring a durable exchange of the given kind
+func declareExchange(exchange string, kind func() string) func(*amqp.Channel) error {
+	return func(c *amqp.Channel) error {
+		if err := c.ExchangeDeclare(exchange, kind(), true, false, false, false, nil); err != nil {
+			return fmt.Errorf("exchange.declare: %v", err)
+		}
+
+		return nil
+	}
+}
+
+func topicExchange() string {
+	return "topic"
+}
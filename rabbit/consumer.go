@@ -0,0 +1,26 @@
+package rabbit
+
+import (
+	"github.com/andrewwebber/cqrs"
+
+	"github.com/streadway/amqp"
+)
+
+// consumer is a channel consuming the deliveries of a queue under its own consumer tag
+type consumer struct {
+	channel    *amqp.Channel
+	tag        string
+	deliveries <-chan amqp.Delivery
+	// retries publishes failed deliveries to the retry queue or the dead letter exchange. It is nil without a retry policy
+	retries *confirmedPublisher
+}
+
+// consumerTag returns a consumer tag for a listener of a queue, unique so that cancelling it stops only that listener
+func consumerTag(queue string) string {
+	return queue + "." + cqrs.NewUUIDString()
+}
+
+// cancel stops the broker from delivering further messages to the consumer. Deliveries already received can still be acknowledged
+func (c *consumer) cancel() error {
+	return c.channel.Cancel(c.tag, false)
+}
@@ -0,0 +1,13 @@
+package rabbit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConsumerTag(t *testing.T) {
+	first, second := consumerTag("commands"), consumerTag("commands")
+	if !strings.HasPrefix(first, "commands.") || first == second {
+		t.Fatal("Expected unique consumer tags named after the queue but got", first, second)
+	}
+}
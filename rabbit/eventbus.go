@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/andrewwebber/cqrs"
@@ -27,45 +26,42 @@ type RawVersionedEvent struct {
 
 // EventBus  ...
 type EventBus struct {
-	resolver          ConnectionStringResolver
-	name              string
-	exchange          string
-	channel           *amqp.Channel
-	reconnect         chan reconnectionAttempt
-	conn              *amqp.Connection
-	reconnectContext  int
-	healthyconnection uint32
+	busConnection
+	name            string
+	exchange        string
+	retryPolicy     *RetryPolicy
+	orderedWorkers  int
+	orderingKeyFunc func(cqrs.VersionedEvent) string
+	routeByType     bool
+	routingKeyFunc  func(cqrs.VersionedEvent) string
+	bindings        []string
+	boundLock       sync.Mutex
+	bound           []string
 }
 
+// ErrEventRoutingBindingsRequired is returned when receiving events routed by a custom key without binding patterns.
+// Queues are only bound to event type names by default, which never match the keys of a custom key function
+var ErrEventRoutingBindingsRequired = errors.New("event routing with a custom key requires binding patterns")
+
 // NewEventBus ...
 func NewEventBus(resolver ConnectionStringResolver, name string, exchange string) *EventBus {
-	bus := &EventBus{resolver: resolver, name: name, exchange: exchange, healthyconnection: 1}
-	reconnectCh := initializeReconnectionManagement(resolver, func(conn *amqp.Connection, ctx int) {
-		bus.conn = conn
-		bus.reconnectContext = ctx
-		_ = bus.connect(bus.conn)
-	})
-	respCh := make(chan reconnectionAttemptResponse)
-	reconnectCh <- reconnectionAttempt{context: 0, response: respCh}
-	<-respCh
-	bus.reconnect = reconnectCh
+	bus := &EventBus{name: name, exchange: exchange}
+	bus.open(resolver, declareExchange(exchange, bus.exchangeKind))
 
 	return bus
 }
 
-func (bus *EventBus) getConnectionString() (string, error) {
-	var connectionString string
-	retryError := exponential(func() error {
-		result, err := bus.resolver()
-		if err != nil {
-			return err
-		}
+// NewEventBusWithTypeRouting creates an event bus declaring the exchange as a topic exchange and publishing events with a routing key
+// derived from their event type, or from the given key function such as one returning the aggregate category. The event queue is bound
+// to the given binding patterns or, without binding patterns, only to the event types handled by the receiving dispatcher so irrelevant
+// events are never delivered. Binding patterns are required with a key function, as its keys are unrelated to event type names.
+// An existing fanout exchange cannot be redeclared as a topic exchange, so topic routing needs an exchange of its own
+func NewEventBusWithTypeRouting(resolver ConnectionStringResolver, name string, exchange string, key func(cqrs.VersionedEvent) string, bindings ...string) *EventBus {
+	bus := &EventBus{name: name, exchange: exchange}
+	bus.setEventTypeRouting(key, bindings...)
+	bus.open(resolver, declareExchange(exchange, bus.exchangeKind))
 
-		connectionString = result
-		return err
-	}, 5)
-
-	return connectionString, retryError
+	return bus
 }
 
 // PublishEvents will publish events
@@ -87,32 +83,9 @@ func (bus *EventBus) PublishEvents(events []cqrs.VersionedEvent) error {
 			Body:            encodedEvent,
 		}
 
-		retryError := exponential(func() error {
-			err = bus.channel.Publish(bus.exchange, bus.name, true, false, msg)
-
-			if err != nil {
-				atomic.CompareAndSwapUint32(&bus.healthyconnection, 1, 0)
-				respCh := make(chan reconnectionAttemptResponse)
-				bus.reconnect <- reconnectionAttempt{context: bus.reconnectContext, response: respCh}
-				resp := <-respCh
-				bus.conn = resp.connection
-				bus.reconnectContext = resp.newContext
-
-				connErr := bus.connect(bus.conn)
-				if connErr == nil {
-					cqrs.PackageLogger().Debugf("RabbitMQ: Reconnected")
-					atomic.CompareAndSwapUint32(&bus.healthyconnection, 0, 1)
-				} else {
-					cqrs.PackageLogger().Debugf("RabbitMQ: Reconnect Failed %v", err)
-				}
-			}
-
-			return err
-		}, 3)
-
-		if retryError != nil {
+		if err := bus.publish(bus.exchange, bus.routingKey(event), msg); err != nil {
 			metricsEventsFailed.WithLabelValues(event.EventType).Inc()
-			return fmt.Errorf("bus.publish: %v", err)
+			return err
 		}
 
 		metricsEventsPublished.WithLabelValues(event.EventType).Inc()
@@ -121,149 +94,237 @@ func (bus *EventBus) PublishEvents(events []cqrs.VersionedEvent) error {
 	return nil
 }
 
-func (bus *EventBus) connect(conn *amqp.Connection) error {
+// SetRetryPolicy configures bounded retries and dead lettering of events whose handlers fail.
+// Without a retry policy failed events are requeued. The policy must be set before receiving events
+func (bus *EventBus) SetRetryPolicy(policy RetryPolicy) {
+	policy = policy.withDefaults()
+	bus.retryPolicy = &policy
+}
 
-	// This waits for a server acknowledgment which means the sockets will have
-	// flushed all outbound publishings prior to returning.  It's important to
-	// block on Close to not lose any publishings.
-	//defer conn.Close()
+// setEventTypeRouting configures routing by event type before the exchange is declared, see NewEventBusWithTypeRouting
+func (bus *EventBus) setEventTypeRouting(key func(cqrs.VersionedEvent) string, bindings ...string) {
+	bus.routeByType = true
+	bus.routingKeyFunc = key
+	bus.bindings = bindings
+}
 
-	c, err := conn.Channel()
+// EventTypeRoutingKey returns the routing key for events of the given type, suitable as a binding pattern
+func EventTypeRoutingKey(event interface{}) string {
+	return typeRoutingKey(reflect.TypeOf(event).String())
+}
+
+func (bus *EventBus) exchangeKind() string {
+	if bus.routeByType {
+		return "topic"
+	}
+
+	return "fanout"
+}
+
+func (bus *EventBus) routingKey(event cqrs.VersionedEvent) string {
+	if !bus.routeByType {
+		return bus.name
+	}
+
+	if bus.routingKeyFunc != nil {
+		return bus.routingKeyFunc(event)
+	}
+
+	return typeRoutingKey(event.EventType)
+}
+
+// queueBindings returns the binding patterns of the event queue given the event types handled by the receiver
+func (bus *EventBus) queueBindings(eventTypes []string) []string {
+	if !bus.routeByType {
+		return []string{bus.name}
+	}
+
+	if len(bus.bindings) > 0 {
+		return bus.bindings
+	}
+
+	if eventTypes == nil {
+		return []string{"#"}
+	}
+
+	bindings := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		bindings = append(bindings, typeRoutingKey(eventType))
+	}
+
+	return bindings
+}
+
+// SetOrderedDelivery handles received events on a fixed number of worker go routines partitioned by key.
+// Events sharing a key are handled one at a time in the order they were delivered, while events with different keys are
+// handled in parallel. The key defaults to the event's SourceID when key is nil.
+// Order is only guaranteed for deliveries received by the same listener, and events that are retried are redelivered out of order.
+// Ordered delivery must be configured before receiving events
+func (bus *EventBus) SetOrderedDelivery(workers int, key func(cqrs.VersionedEvent) string) {
+	bus.orderedWorkers = workers
+	bus.orderingKeyFunc = key
+}
+
+// BindEventTypes binds the event queue to the event types handled by the receiving dispatcher and unbinds it from the event types no
+// longer handled. It is a no-op unless events are routed by type without binding patterns
+func (bus *EventBus) BindEventTypes(eventTypes []string) error {
+	if !bus.routeByType || len(bus.bindings) > 0 {
+		return nil
+	}
+
+	bus.boundLock.Lock()
+	defer bus.boundLock.Unlock()
+
+	bind, unbind := bindingChanges(bus.bound, bus.queueBindings(eventTypes))
+	if len(bind) == 0 && len(unbind) == 0 {
+		return nil
+	}
+
+	c, err := bus.conn.Channel()
 	if err != nil {
 		return fmt.Errorf("channel.open: %s", err)
 	}
 
-	// We declare our topology on both the publisher and consumer to ensure they
-	// are the same.  This is part of AMQP being a programmable messaging model.
-	//
-	// See the Channel.Consume example for the complimentary declare.
-	err = c.ExchangeDeclare(bus.exchange, "fanout", true, false, false, false, nil)
-	if err != nil {
-		return fmt.Errorf("exchange.declare: %v", err)
+	defer c.Close()
+
+	for _, binding := range bind {
+		if err = c.QueueBind(bus.name, binding, bus.exchange, false, nil); err != nil {
+			return fmt.Errorf("queue.bind: %v", err)
+		}
+
+		bus.bound = append(bus.bound, binding)
 	}
 
-	bus.channel = c
+	for _, binding := range unbind {
+		if err = c.QueueUnbind(bus.name, binding, bus.exchange, nil); err != nil {
+			return fmt.Errorf("queue.unbind: %v", err)
+		}
+
+		// Keep every bound binding except the one just unbound
+		bus.bound, _ = bindingChanges([]string{binding}, bus.bound)
+	}
 
 	return nil
 }
 
+// boundBindings returns the binding patterns the event queue is currently bound to
+func (bus *EventBus) boundBindings() []string {
+	bus.boundLock.Lock()
+	defer bus.boundLock.Unlock()
+	return append([]string{}, bus.bound...)
+}
+
+// bindingChanges returns the bindings in next missing from current and the bindings in current missing from next
+func bindingChanges(current []string, next []string) ([]string, []string) {
+	difference := func(a []string, b []string) []string {
+		in := make(map[string]bool, len(b))
+		for _, binding := range b {
+			in[binding] = true
+		}
+
+		var missing []string
+		for _, binding := range a {
+			if !in[binding] {
+				missing = append(missing, binding)
+			}
+		}
+
+		return missing
+	}
+
+	return difference(next, current), difference(current, next)
+}
+
 // ReceiveEvents will receive events
 func (bus *EventBus) ReceiveEvents(options cqrs.VersionedEventReceiverOptions) error {
-	conn := bus.conn
-	listenerStart := time.Now()
-	var wg sync.WaitGroup
-	for n := 0; n < options.ListenerCount; n++ {
-		wg.Add(1)
-		go func(reconnectionChannel chan<- reconnectionAttempt) {
-			reconnectionContext := 0
-			c, events, err := bus.consumeEventsQueue(conn, options.Exclusive)
-			if err != nil {
-				return
-			}
+	if bus.routingKeyFunc != nil && len(bus.bindings) == 0 {
+		return ErrEventRoutingBindingsRequired
+	}
 
-			wg.Done()
-			notifyClose := conn.NotifyClose(make(chan *amqp.Error))
-			reconnect := func() {
-				atomic.CompareAndSwapUint32(&bus.healthyconnection, 1, 0)
-				respCh := make(chan reconnectionAttemptResponse)
-				reconnectionChannel <- reconnectionAttempt{context: reconnectionContext, response: respCh}
-				resp := <-respCh
-				conn = resp.connection
-				reconnectionContext = resp.newContext
-
-				cR, eventsR, errR := bus.consumeEventsQueue(conn, options.Exclusive)
-				if errR == nil {
-					c, events, _ = cR, eventsR, errR
-				}
-				notifyClose = conn.NotifyClose(make(chan *amqp.Error))
-				atomic.CompareAndSwapUint32(&bus.healthyconnection, 0, 1)
+	bus.boundLock.Lock()
+	bus.bound = bus.queueBindings(options.EventTypes)
+	bus.boundLock.Unlock()
+
+	receiver := &deliveryReceiver{
+		name:              "events",
+		conn:              bus.conn,
+		reconnect:         bus.reconnect,
+		healthyconnection: &bus.healthyconnection,
+		orderedWorkers:    bus.orderedWorkers,
+		close:             options.Close,
+		errors:            options.Error,
+		consume: func(conn *amqp.Connection) (*consumer, error) {
+			return bus.consumeEventsQueue(conn, options.Exclusive, bus.boundBindings())
+		},
+		prepare: func(c *consumer, message amqp.Delivery) (func(), string, bool) {
+			versionedEvent, ok := bus.decodeDelivery(message, options)
+			if !ok {
+				return nil, "", false
 			}
 
-			for {
-				select {
-				case ch := <-options.Close:
-					defer closeConnection(conn)
-					ch <- c.Cancel(bus.name, false)
-					return
-
-				case <-notifyClose:
-					reconnect()
-
-				case m, more := <-events:
-					if more {
-						go func(message amqp.Delivery) {
-							var raw RawVersionedEvent
-							if errUnmarshalRaw := json.Unmarshal(message.Body, &raw); errUnmarshalRaw != nil {
-								options.Error <- fmt.Errorf("json.Unmarshal received event: %v", errUnmarshalRaw)
-							} else {
-								eventType, ok := options.TypeRegistry.GetTypeByName(raw.EventType)
-								if !ok {
-									// cqrs.PackageLogger().Debugf(nil, "EventBus.Cannot find event type", raw.EventType)
-									// options.Error <- errors.New("Cannot find event type " + raw.EventType)
-									err = message.Ack(false)
-									if err != nil {
-										cqrs.PackageLogger().Debugf("ERROR: Message ack failed: %v\n", err)
-									}
-								} else {
-									eventValue := reflect.New(eventType)
-									event := eventValue.Interface()
-									if errUnmarshalEvent := json.Unmarshal(raw.Event, event); errUnmarshalEvent != nil {
-										options.Error <- errors.New("Error deserializing event " + raw.EventType)
-									} else {
-										versionedEvent := cqrs.VersionedEvent{
-											ID:            raw.ID,
-											CorrelationID: raw.CorrelationID,
-											SourceID:      raw.SourceID,
-											Version:       raw.Version,
-											EventType:     raw.EventType,
-											Created:       raw.Created,
-
-											Event: reflect.Indirect(eventValue).Interface()}
-
-										start := time.Now()
-										execErr := options.ReceiveEvent(versionedEvent)
-										result := execErr == nil
-										if result {
-											err = message.Ack(false)
-											if err != nil {
-												cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
-											}
-											elapsed := time.Since(start)
-											// stats := map[string]string{
-											// 	"CQRS_LOG":      "true",
-											// 	"CQRS_DURATION": fmt.Sprintf("%s", elapsed),
-											// 	"CQRS_TYPE":     raw.EventType,
-											// 	"CQRS_CREATED":  fmt.Sprintf("%s", raw.Created),
-											// 	"CQRS_CORR":     raw.CorrelationID}
-											cqrs.PackageLogger().Debugf("EventBus Message Took %s", elapsed)
-										} else {
-											err = message.Reject(true)
-											if err != nil {
-												cqrs.PackageLogger().Debugf("ERROR: Message reject returned error: %v\n", err)
-											}
-										}
-									}
-								}
-							}
-						}(m)
-					} else {
-						c, events, err = bus.consumeEventsQueue(conn, options.Exclusive)
-						for err != nil {
-							reconnect()
-							c, events, err = bus.consumeEventsQueue(conn, options.Exclusive)
-							<-time.After(1 * time.Second)
-						}
-					}
-				}
-			}
-		}(bus.reconnect)
+			return func() { bus.handleEvent(c, message, versionedEvent, options) }, bus.orderingKey(versionedEvent), true
+		},
 	}
 
-	wg.Wait()
-	listenerElapsed := time.Since(listenerStart)
-	cqrs.PackageLogger().Debugf("Receiving events - %s", listenerElapsed)
+	return receiver.start(options.ListenerCount)
+}
 
-	return nil
+// decodeDelivery deserializes the versioned event carried by a delivery.
+// Deliveries of event types missing from the type registry are acknowledged and skipped
+func (bus *EventBus) decodeDelivery(message amqp.Delivery, options cqrs.VersionedEventReceiverOptions) (cqrs.VersionedEvent, bool) {
+	var raw RawVersionedEvent
+	if errUnmarshalRaw := json.Unmarshal(message.Body, &raw); errUnmarshalRaw != nil {
+		options.Error <- cqrs.NewReceiverError(cqrs.ReceiverErrorDeserialization, "", fmt.Errorf("json.Unmarshal received event: %v", errUnmarshalRaw))
+		return cqrs.VersionedEvent{}, false
+	}
+
+	eventType, ok := options.TypeRegistry.GetTypeByName(raw.EventType)
+	if !ok {
+		if err := message.Ack(false); err != nil {
+			cqrs.PackageLogger().Debugf("ERROR: Message ack failed: %v\n", err)
+		}
+
+		return cqrs.VersionedEvent{}, false
+	}
+
+	eventValue := reflect.New(eventType)
+	if errUnmarshalEvent := json.Unmarshal(raw.Event, eventValue.Interface()); errUnmarshalEvent != nil {
+		options.Error <- cqrs.NewReceiverError(cqrs.ReceiverErrorDeserialization, raw.EventType, fmt.Errorf("Error deserializing event %s: %v", raw.EventType, errUnmarshalEvent))
+		return cqrs.VersionedEvent{}, false
+	}
+
+	return cqrs.VersionedEvent{
+		ID:            raw.ID,
+		CorrelationID: raw.CorrelationID,
+		SourceID:      raw.SourceID,
+		Version:       raw.Version,
+		EventType:     raw.EventType,
+		Created:       raw.Created,
+
+		Event: reflect.Indirect(eventValue).Interface()}, true
+}
+
+// handleEvent passes a versioned event to the receiver and acknowledges or rejects its delivery
+func (bus *EventBus) handleEvent(c *consumer, message amqp.Delivery, versionedEvent cqrs.VersionedEvent, options cqrs.VersionedEventReceiverOptions) {
+	start := time.Now()
+	if execErr := options.ReceiveEvent(versionedEvent); execErr != nil {
+		rejectDelivery(c, message, bus.exchange, bus.name, bus.retryPolicy, cqrs.NewEventDeadLetter(versionedEvent, execErr))
+		return
+	}
+
+	if err := message.Ack(false); err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
+	}
+
+	cqrs.PackageLogger().Debugf("EventBus Message Took %s", time.Since(start))
+}
+
+func (bus *EventBus) orderingKey(event cqrs.VersionedEvent) string {
+	if bus.orderingKeyFunc != nil {
+		return bus.orderingKeyFunc(event)
+	}
+
+	return event.SourceID
 }
 
 // DeleteQueue will delete a queue
@@ -288,38 +349,54 @@ func (bus *EventBus) DeleteQueue(name string) error {
 	return err
 }
 
-func (bus *EventBus) consumeEventsQueue(conn *amqp.Connection, exclusive bool) (*amqp.Channel, <-chan amqp.Delivery, error) {
+func (bus *EventBus) consumeEventsQueue(conn *amqp.Connection, exclusive bool, bindings []string) (*consumer, error) {
 
 	c, err := conn.Channel()
 	if err != nil {
-		return nil, nil, fmt.Errorf("channel.open: %s", err)
+		return nil, fmt.Errorf("channel.open: %s", err)
 	}
 
 	// We declare our topology on both the publisher and consumer to ensure they
 	// are the same.  This is part of AMQP being a programmable messaging model.
 	//
 	// See the Channel.Consume example for the complimentary declare.
-	err = c.ExchangeDeclare(bus.exchange, "fanout", true, false, false, false, nil)
+	err = c.ExchangeDeclare(bus.exchange, bus.exchangeKind(), true, false, false, false, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("exchange.declare: %v", err)
+		return nil, fmt.Errorf("exchange.declare: %v", err)
 	}
 
 	if _, err = c.QueueDeclare(bus.name, true, false, false, false, nil); err != nil {
-		return nil, nil, fmt.Errorf("queue.declare: %v", err)
+		return nil, fmt.Errorf("queue.declare: %v", err)
 	}
 
-	if err = c.QueueBind(bus.name, bus.name, bus.exchange, false, nil); err != nil {
-		return nil, nil, fmt.Errorf("queue.bind: %v", err)
+	for _, binding := range bindings {
+		if err = c.QueueBind(bus.name, binding, bus.exchange, false, nil); err != nil {
+			return nil, fmt.Errorf("queue.bind: %v", err)
+		}
 	}
 
-	events, err := c.Consume(bus.name, "", false, exclusive, false, false, nil)
-	if err != nil {
-		return nil, nil, fmt.Errorf("basic.consume: %v", err)
+	if bus.retryPolicy != nil {
+		if err = declareRetryTopology(c, bus.exchange, bus.name, bus.retryPolicy); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := c.Qos(Prefetch, 0, false); err != nil {
-		return nil, nil, fmt.Errorf("Qos: %v", err)
+		return nil, fmt.Errorf("Qos: %v", err)
+	}
+
+	tag := consumerTag(bus.name)
+	events, err := c.Consume(bus.name, tag, false, exclusive, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("basic.consume: %v", err)
+	}
+
+	receiver := &consumer{channel: c, tag: tag, deliveries: events}
+	if bus.retryPolicy != nil {
+		if receiver.retries, err = newConfirmedPublisher(conn); err != nil {
+			return nil, err
+		}
 	}
 
-	return c, events, nil
+	return receiver, nil
 }
@@ -7,6 +7,11 @@ var (
 	metricsCommandsFailed    *prometheus.CounterVec
 	metricsEventsPublished   *prometheus.CounterVec
 	metricsEventsFailed      *prometheus.CounterVec
+	metricsQueriesPublished  *prometheus.CounterVec
+	metricsQueriesFailed     *prometheus.CounterVec
+
+	metricsMessagesRetried      *prometheus.CounterVec
+	metricsMessagesDeadLettered *prometheus.CounterVec
 )
 
 func init() {
@@ -34,5 +39,29 @@ func init() {
 		Help:      "CQRS Events Failed",
 	}, []string{"event"})
 
-	prometheus.MustRegister(metricsCommandsPublished, metricsCommandsFailed, metricsEventsPublished, metricsEventsFailed)
+	metricsQueriesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rabbit_queries_published",
+		Subsystem: "ix",
+		Help:      "CQRS Queries Published",
+	}, []string{"query"})
+
+	metricsQueriesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rabbit_queries_failed",
+		Subsystem: "ix",
+		Help:      "CQRS Queries Failed",
+	}, []string{"query"})
+
+	metricsMessagesRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rabbit_messages_retried",
+		Subsystem: "ix",
+		Help:      "CQRS Messages Scheduled For Retry",
+	}, []string{"queue"})
+
+	metricsMessagesDeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "rabbit_messages_dead_lettered",
+		Subsystem: "ix",
+		Help:      "CQRS Messages Dead Lettered",
+	}, []string{"queue"})
+
+	prometheus.MustRegister(metricsCommandsPublished, metricsCommandsFailed, metricsEventsPublished, metricsEventsFailed, metricsQueriesPublished, metricsQueriesFailed, metricsMessagesRetried, metricsMessagesDeadLettered)
 }
@@ -0,0 +1,49 @@
+package rabbit
+
+import (
+	"hash/fnv"
+)
+
+// orderedWorkers runs work on a fixed number of go routines, always running work sharing a key on the same go routine.
+// Work sharing a key is therefore handled in the order it was dispatched while work with different keys runs in parallel
+type orderedWorkers struct {
+	queues []chan func()
+}
+
+func newOrderedWorkers(workers int) *orderedWorkers {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ordered := &orderedWorkers{queues: make([]chan func(), workers)}
+	for i := range ordered.queues {
+		queue := make(chan func(), Prefetch)
+		ordered.queues[i] = queue
+		go func() {
+			for work := range queue {
+				work()
+			}
+		}()
+	}
+
+	return ordered
+}
+
+// dispatch queues work on the go routine responsible for the given key
+func (o *orderedWorkers) dispatch(key string, work func()) {
+	o.queues[o.worker(key)] <- work
+}
+
+// worker returns the index of the go routine responsible for the given key
+func (o *orderedWorkers) worker(key string) uint32 {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return hash.Sum32() % uint32(len(o.queues))
+}
+
+// stop lets the workers finish queued work and exit
+func (o *orderedWorkers) stop() {
+	for _, queue := range o.queues {
+		close(queue)
+	}
+}
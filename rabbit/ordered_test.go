@@ -0,0 +1,73 @@
+package rabbit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderedWorkersKeepOrderPerKey(t *testing.T) {
+	ordered := newOrderedWorkers(4)
+	keys := []string{"a", "b", "c", "d", "e"}
+
+	var lock sync.Mutex
+	handled := make(map[string][]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		for _, key := range keys {
+			key, i := key, i
+			wg.Add(1)
+			ordered.dispatch(key, func() {
+				defer wg.Done()
+				if i%7 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+
+				lock.Lock()
+				handled[key] = append(handled[key], i)
+				lock.Unlock()
+			})
+		}
+	}
+
+	wg.Wait()
+	ordered.stop()
+
+	for _, key := range keys {
+		if len(handled[key]) != 100 {
+			t.Fatal("Expected every work item to be handled for key", key, "but got", len(handled[key]))
+		}
+
+		for i, n := range handled[key] {
+			if i != n {
+				t.Fatal("Expected work for key", key, "to be handled in dispatch order but got", handled[key])
+			}
+		}
+	}
+}
+
+func TestOrderedWorkersRunKeysInParallel(t *testing.T) {
+	ordered := newOrderedWorkers(2)
+	defer ordered.stop()
+
+	blocked, other := "blocked", ""
+	for i := 0; other == ""; i++ {
+		if key := fmt.Sprint("key", i); ordered.worker(key) != ordered.worker(blocked) {
+			other = key
+		}
+	}
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	ordered.dispatch(blocked, func() { <-release })
+	ordered.dispatch(other, func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected work with another key to run while the first key is blocked")
+	}
+
+	close(release)
+}
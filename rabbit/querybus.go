@@ -0,0 +1,195 @@
+package rabbit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+
+	"github.com/streadway/amqp"
+)
+
+// RawQuery represents an actor request to read the state of the system
+type RawQuery struct {
+	MessageID     string    `json:"messageID"`
+	CorrelationID string    `json:"correlationID"`
+	QueryType     string    `json:"queryType"`
+	Actor         string    `json:"actor"`
+	OnBehalfOf    string    `json:"onbehalfof"`
+	Created       time.Time `json:"time"`
+	Body          json.RawMessage
+}
+
+// QueryBus sends queries over RabbitMQ and returns their results through an exclusive reply queue
+type QueryBus struct {
+	busConnection
+	name         string
+	exchange     string
+	queryTimeout time.Duration
+	replies      replies
+}
+
+// NewQueryBus ...
+func NewQueryBus(resolver ConnectionStringResolver, name string, exchange string) *QueryBus {
+	bus := &QueryBus{name: name, exchange: exchange, queryTimeout: cqrs.DefaultQueryTimeout}
+	bus.open(resolver, declareExchange(exchange, topicExchange))
+
+	return bus
+}
+
+// SetQueryTimeout sets the duration SendQuery waits for a query result. Queries not received within the timeout expire
+func (bus *QueryBus) SetQueryTimeout(timeout time.Duration) {
+	bus.queryTimeout = timeout
+}
+
+// SendQuery will publish a query and wait for the result of its query handler.
+// Results are returned through an exclusive reply queue and matched to the query using its message ID.
+func (bus *QueryBus) SendQuery(query cqrs.Query) (cqrs.QueryResult, error) {
+	replies, err := bus.replies.get(bus.conn)
+	if err != nil {
+		return cqrs.QueryResult{}, err
+	}
+
+	var result cqrs.QueryResult
+	publish := func(replyTo string) error {
+		return bus.publishQuery(query, replyTo)
+	}
+
+	if err := replies.request(query.MessageID, publish, bus.queryTimeout, cqrs.ErrQueryTimeout, &result); err != nil {
+		return cqrs.QueryResult{}, err
+	}
+
+	return result, nil
+}
+
+func (bus *QueryBus) publishQuery(query cqrs.Query, replyTo string) error {
+	encodedQuery, err := json.Marshal(query)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+
+	// Queries are only relevant while their sender waits for the result, so they are transient and expire with the timeout
+	msg := amqp.Publishing{
+		DeliveryMode:    amqp.Transient,
+		Timestamp:       time.Now().UTC(),
+		ContentEncoding: "UTF-8",
+		ContentType:     "text/plain",
+		Expiration:      strconv.FormatInt(int64(bus.queryTimeout/time.Millisecond), 10),
+		ReplyTo:         replyTo,
+		CorrelationId:   query.MessageID,
+		Body:            encodedQuery,
+	}
+
+	if err := bus.publish(bus.exchange, bus.name, msg); err != nil {
+		metricsQueriesFailed.WithLabelValues(query.QueryType).Inc()
+		return err
+	}
+
+	metricsQueriesPublished.WithLabelValues(query.QueryType).Inc()
+
+	return nil
+}
+
+// ReceiveQueries will receive queries and reply with their results
+func (bus *QueryBus) ReceiveQueries(options cqrs.QueryReceiverOptions) error {
+	receiver := &deliveryReceiver{
+		name:              "queries",
+		conn:              bus.conn,
+		reconnect:         bus.reconnect,
+		healthyconnection: &bus.healthyconnection,
+		close:             options.Close,
+		errors:            options.Error,
+		consume: func(conn *amqp.Connection) (*consumer, error) {
+			return bus.consumeQueriesQueue(conn, options.Exclusive)
+		},
+		prepare: func(c *consumer, message amqp.Delivery) (func(), string, bool) {
+			return func() { bus.receiveDelivery(c, message, options) }, "", true
+		},
+	}
+
+	return receiver.start(options.ListenerCount)
+}
+
+// receiveDelivery answers a query and acknowledges its delivery. Queries that cannot be decoded are answered with an error
+func (bus *QueryBus) receiveDelivery(c *consumer, message amqp.Delivery, options cqrs.QueryReceiverOptions) {
+	query, err := bus.decodeDelivery(message, options)
+	if err != nil {
+		options.Error <- err
+		replyJSON(c.channel, message, cqrs.NewQueryResult(query, nil, err))
+	} else {
+		result, execErr := options.ReceiveQuery(query)
+		replyJSON(c.channel, message, cqrs.NewQueryResult(query, result, execErr))
+	}
+
+	if err := message.Ack(false); err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
+	}
+}
+
+// decodeDelivery deserializes the query carried by a delivery
+func (bus *QueryBus) decodeDelivery(message amqp.Delivery, options cqrs.QueryReceiverOptions) (cqrs.Query, error) {
+	query := cqrs.Query{MessageID: message.CorrelationId}
+
+	var raw RawQuery
+	if errUnmarshalRaw := json.Unmarshal(message.Body, &raw); errUnmarshalRaw != nil {
+		return query, cqrs.NewReceiverError(cqrs.ReceiverErrorDeserialization, "", fmt.Errorf("json.Unmarshal received query: %v", errUnmarshalRaw))
+	}
+
+	query = cqrs.Query{
+		MessageID:     raw.MessageID,
+		CorrelationID: raw.CorrelationID,
+		QueryType:     raw.QueryType,
+		Actor:         raw.Actor,
+		OnBehalfOf:    raw.OnBehalfOf,
+		Created:       raw.Created}
+
+	queryType, ok := options.TypeRegistry.GetTypeByName(raw.QueryType)
+	if !ok {
+		return query, cqrs.NewReceiverError(cqrs.ReceiverErrorUnknownType, raw.QueryType, errors.New("Cannot find query type "+raw.QueryType))
+	}
+
+	queryValue := reflect.New(queryType)
+	if errUnmarshalBody := json.Unmarshal(raw.Body, queryValue.Interface()); errUnmarshalBody != nil {
+		return query, cqrs.NewReceiverError(cqrs.ReceiverErrorDeserialization, raw.QueryType, fmt.Errorf("Error deserializing query %s: %v", raw.QueryType, errUnmarshalBody))
+	}
+
+	query.Body = reflect.Indirect(queryValue).Interface()
+
+	return query, nil
+}
+
+func (bus *QueryBus) consumeQueriesQueue(conn *amqp.Connection, exclusive bool) (*consumer, error) {
+	c, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("channel.open: %s", err)
+	}
+
+	err = c.ExchangeDeclare(bus.exchange, "topic", true, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("exchange.declare: %v", err)
+	}
+
+	if _, err = c.QueueDeclare(bus.name, false, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("queue.declare: %v", err)
+	}
+
+	if err = c.QueueBind(bus.name, bus.name, bus.exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("queue.bind: %v", err)
+	}
+
+	if err := c.Qos(Prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("Qos: %v", err)
+	}
+
+	tag := consumerTag(bus.name)
+	queries, err := c.Consume(bus.name, tag, false, exclusive, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("basic.consume: %v", err)
+	}
+
+	return &consumer{channel: c, tag: tag, deliveries: queries}, nil
+}
@@ -0,0 +1,146 @@
+package rabbit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+
+	"github.com/streadway/amqp"
+)
+
+// deliveryReceiver runs the listener go routines shared by the command, event and query receivers.
+// Every listener consumes the queue on a channel of its own and resumes consuming after losing its connection.
+// On a close request a listener cancels its consumer and waits for in-flight deliveries before closing the connection
+type deliveryReceiver struct {
+	name              string
+	conn              *amqp.Connection
+	reconnect         chan<- reconnectionAttempt
+	healthyconnection *uint32
+	orderedWorkers    int
+	close             <-chan chan error
+	errors            chan<- error
+	// consume declares the queue and starts consuming it on a new channel of the connection
+	consume func(*amqp.Connection) (*consumer, error)
+	// prepare decodes a delivery, returning the work handling it and the key ordering it, or false when the delivery was already settled
+	prepare func(*consumer, amqp.Delivery) (func(), string, bool)
+}
+
+// start starts the listeners and returns once each of them is consuming, or with the error of a listener that could not consume
+func (r *deliveryReceiver) start(listenerCount int) error {
+	listenerStart := time.Now()
+	started := make(chan error, listenerCount)
+	var inFlight sync.WaitGroup
+	for n := 0; n < listenerCount; n++ {
+		go r.listen(started, &inFlight)
+	}
+
+	var startErr error
+	for n := 0; n < listenerCount; n++ {
+		if err := <-started; err != nil && startErr == nil {
+			startErr = err
+		}
+	}
+
+	cqrs.PackageLogger().Debugf("Receiving %s - %s", r.name, time.Since(listenerStart))
+
+	return startErr
+}
+
+func (r *deliveryReceiver) listen(started chan<- error, inFlight *sync.WaitGroup) {
+	conn := r.conn
+	reconnectionContext := 0
+	c, err := r.consume(conn)
+	started <- err
+	if err != nil {
+		return
+	}
+
+	var ordered *orderedWorkers
+	if r.orderedWorkers > 0 {
+		ordered = newOrderedWorkers(r.orderedWorkers)
+	}
+
+	notifyClose := conn.NotifyClose(make(chan *amqp.Error))
+	reconnect := func() {
+		atomic.CompareAndSwapUint32(r.healthyconnection, 1, 0)
+		respCh := make(chan reconnectionAttemptResponse)
+		r.reconnect <- reconnectionAttempt{context: reconnectionContext, response: respCh}
+		resp := <-respCh
+		conn = resp.connection
+		reconnectionContext = resp.newContext
+		notifyClose = conn.NotifyClose(make(chan *amqp.Error))
+		atomic.CompareAndSwapUint32(r.healthyconnection, 0, 1)
+	}
+
+	// resume consumes the queue again, reconnecting until consuming succeeds
+	resume := func() {
+		for {
+			next, err := r.consume(conn)
+			if err == nil {
+				c = next
+				return
+			}
+
+			reconnect()
+			<-time.After(1 * time.Second)
+		}
+	}
+
+	for {
+		select {
+		case ch := <-r.close:
+			cqrs.PackageLogger().Debugf("Close requested")
+			// Stop consuming, then let in-flight deliveries be acknowledged or rejected before closing the connection
+			errCancel := c.cancel()
+			if ordered != nil {
+				ordered.stop()
+			}
+
+			inFlight.Wait()
+			closeConnection(conn)
+			ch <- errCancel
+			return
+
+		case errClose := <-notifyClose:
+			if errClose != nil {
+				r.errors <- cqrs.NewReceiverError(cqrs.ReceiverErrorConnection, "", errClose)
+			}
+
+			reconnect()
+			resume()
+
+		case message, more := <-c.deliveries:
+			if !more {
+				resume()
+				continue
+			}
+
+			work, key, ok := r.prepare(c, message)
+			if !ok {
+				continue
+			}
+
+			inFlight.Add(1)
+			if ordered == nil {
+				go func() {
+					defer inFlight.Done()
+					work()
+				}()
+			} else {
+				ordered.dispatch(key, func() {
+					defer inFlight.Done()
+					work()
+				})
+			}
+		}
+	}
+}
+
+func closeConnection(conn *amqp.Connection) {
+	err := conn.Close()
+	if err != nil {
+		cqrs.PackageLogger().Debugf("Couldn't close conn: %v\n", err)
+	}
+}
@@ -0,0 +1,157 @@
+package rabbit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+
+	"github.com/streadway/amqp"
+)
+
+// replies holds the reply consumer of a bus, opening a new one when its reply queue is no longer consumed
+type replies struct {
+	lock     sync.Mutex
+	consumer *replyConsumer
+}
+
+func (r *replies) get(conn *amqp.Connection) (*replyConsumer, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.consumer == nil || r.consumer.isClosed() {
+		consumer, err := newReplyConsumer(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		r.consumer = consumer
+	}
+
+	return r.consumer, nil
+}
+
+// replyConsumer consumes an exclusive reply queue and routes replies to waiting callers by correlation ID
+type replyConsumer struct {
+	queue   string
+	lock    sync.Mutex
+	pending map[string]chan amqp.Delivery
+	closed  chan struct{}
+}
+
+func newReplyConsumer(conn *amqp.Connection) (*replyConsumer, error) {
+	c, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("channel.open: %s", err)
+	}
+
+	// A server named, exclusive and auto deleted queue lives as long as this connection
+	queue, err := c.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("queue.declare: %v", err)
+	}
+
+	replies, err := c.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("basic.consume: %v", err)
+	}
+
+	consumer := &replyConsumer{queue: queue.Name, pending: make(map[string]chan amqp.Delivery), closed: make(chan struct{})}
+	go func() {
+		defer close(consumer.closed)
+		for reply := range replies {
+			consumer.lock.Lock()
+			ch, ok := consumer.pending[reply.CorrelationId]
+			consumer.lock.Unlock()
+			if !ok {
+				cqrs.PackageLogger().Debugf("RabbitMQ: Discarding unexpected reply %s", reply.CorrelationId)
+				continue
+			}
+
+			select {
+			case ch <- reply:
+			default:
+			}
+		}
+	}()
+
+	return consumer, nil
+}
+
+// expect registers interest in a reply with the given correlation ID
+func (r *replyConsumer) expect(correlationID string) <-chan amqp.Delivery {
+	ch := make(chan amqp.Delivery, 1)
+	r.lock.Lock()
+	r.pending[correlationID] = ch
+	r.lock.Unlock()
+	return ch
+}
+
+// forget removes interest in a reply with the given correlation ID
+func (r *replyConsumer) forget(correlationID string) {
+	r.lock.Lock()
+	delete(r.pending, correlationID)
+	r.lock.Unlock()
+}
+
+// request publishes a message expecting a reply with the given correlation ID and decodes the reply into result.
+// publish is passed the reply queue. errTimeout is returned when no reply is received within the timeout
+func (r *replyConsumer) request(correlationID string, publish func(replyTo string) error, timeout time.Duration, errTimeout error, result interface{}) error {
+	reply := r.expect(correlationID)
+	defer r.forget(correlationID)
+
+	if err := publish(r.queue); err != nil {
+		return err
+	}
+
+	select {
+	case message := <-reply:
+		if err := json.Unmarshal(message.Body, result); err != nil {
+			return fmt.Errorf("json.Unmarshal reply: %v", err)
+		}
+
+		return nil
+	case <-time.After(timeout):
+		return errTimeout
+	}
+}
+
+// isClosed reports whether the reply queue is no longer being consumed, for example after a connection loss
+func (r *replyConsumer) isClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// replyJSON sends a JSON encoded result to the queue specified by the received message, if any
+func replyJSON(c *amqp.Channel, message amqp.Delivery, result interface{}) {
+	if len(message.ReplyTo) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: json.Marshal reply: %v\n", err)
+		return
+	}
+
+	if err := publishReply(c, message, body); err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: Reply returned error: %v\n", err)
+	}
+}
+
+// publishReply sends a reply to the queue specified by the received message
+func publishReply(c *amqp.Channel, message amqp.Delivery, body []byte) error {
+	return c.Publish("", message.ReplyTo, false, false, amqp.Publishing{
+		CorrelationId:   message.CorrelationId,
+		Timestamp:       time.Now().UTC(),
+		ContentEncoding: "UTF-8",
+		ContentType:     "application/json",
+		Body:            body,
+	})
+}
@@ -0,0 +1,12 @@
+package rabbit
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestReplyJSONWithoutReplyTo(t *testing.T) {
+	// Deliveries sent without expecting a result are never replied to, so no channel is needed
+	replyJSON(nil, amqp.Delivery{CorrelationId: "1"}, struct{}{})
+}
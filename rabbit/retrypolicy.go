@@ -0,0 +1,227 @@
+package rabbit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	attemptsHeader = "x-cqrs-attempts"
+	errorHeader    = "x-cqrs-error"
+)
+
+// DefaultRetryMaxAttempts is the number of times a message is handled before it is dead lettered when a retry policy does not set MaxAttempts
+var DefaultRetryMaxAttempts = 5
+
+// errPublishNotConfirmed is returned when the broker does not confirm a retried or dead lettered message
+var errPublishNotConfirmed = errors.New("publish was not confirmed by the broker")
+
+// RetryPolicy configures how messages whose handlers fail are retried before being dead lettered.
+//
+// Failed messages are republished to a retry queue named after the consumer queue with a ".retry" suffix and acknowledged once the broker confirmed the copy.
+// Once the delay expires the message is routed back to the consumer queue. After MaxAttempts the message is published to
+// the dead letter exchange and kept in a queue named after the consumer queue with a ".deadletter" suffix for inspection
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a message is handled before it is dead lettered. Defaults to DefaultRetryMaxAttempts
+	MaxAttempts int
+	// Delay is the time a failed message waits in the retry queue before being redelivered
+	Delay time.Duration
+	// DeadLetterExchange receives messages once all attempts have failed. Defaults to the bus exchange with a ".deadletter" suffix
+	DeadLetterExchange string
+	// DeadLetterStore optionally records messages once all attempts have failed
+	DeadLetterStore cqrs.DeadLetterStore
+}
+
+// withDefaults returns the policy with unset fields replaced by their defaults
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = DefaultRetryMaxAttempts
+	}
+
+	return p
+}
+
+func (p *RetryPolicy) deadLetterExchange(exchange string) string {
+	if len(p.DeadLetterExchange) > 0 {
+		return p.DeadLetterExchange
+	}
+
+	return exchange + ".deadletter"
+}
+
+func retryQueueName(queue string) string {
+	return queue + ".retry"
+}
+
+func deadLetterQueueName(queue string) string {
+	return queue + ".deadletter"
+}
+
+// declareRetryTopology declares the retry queue, the dead letter exchange and the dead letter queue for a consumer queue
+func declareRetryTopology(c *amqp.Channel, exchange string, queue string, policy *RetryPolicy) error {
+	retryArgs := amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue,
+	}
+
+	if _, err := c.QueueDeclare(retryQueueName(queue), true, false, false, false, retryArgs); err != nil {
+		return fmt.Errorf("queue.declare: %v", err)
+	}
+
+	deadLetterExchange := policy.deadLetterExchange(exchange)
+	if err := c.ExchangeDeclare(deadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("exchange.declare: %v", err)
+	}
+
+	if _, err := c.QueueDeclare(deadLetterQueueName(queue), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("queue.declare: %v", err)
+	}
+
+	if err := c.QueueBind(deadLetterQueueName(queue), queue, deadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("queue.bind: %v", err)
+	}
+
+	return nil
+}
+
+// deliveryAttempts returns the number of times a message has previously been handled
+func deliveryAttempts(message amqp.Delivery) int {
+	switch attempts := message.Headers[attemptsHeader].(type) {
+	case int32:
+		return int(attempts)
+	case int64:
+		return int(attempts)
+	case int:
+		return attempts
+	default:
+		return 0
+	}
+}
+
+// retryPublishing returns the message republishing a failed delivery, recording the number of attempts and the last error in its headers
+func retryPublishing(message amqp.Delivery, attempts int, lastError string) amqp.Publishing {
+	headers := amqp.Table{}
+	for key, value := range message.Headers {
+		headers[key] = value
+	}
+
+	headers[attemptsHeader] = int32(attempts)
+	headers[errorHeader] = lastError
+
+	return amqp.Publishing{
+		Headers:         headers,
+		DeliveryMode:    amqp.Persistent,
+		Timestamp:       time.Now().UTC(),
+		ContentEncoding: message.ContentEncoding,
+		ContentType:     message.ContentType,
+		CorrelationId:   message.CorrelationId,
+		ReplyTo:         message.ReplyTo,
+		Body:            message.Body,
+	}
+}
+
+// confirmedPublisher publishes retried and dead lettered messages on a channel in confirm mode, waiting for the broker to confirm
+// each message so a failed delivery is only acknowledged once its copy is safely queued
+type confirmedPublisher struct {
+	lock     sync.Mutex
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func newConfirmedPublisher(conn *amqp.Connection) (*confirmedPublisher, error) {
+	c, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("channel.open: %s", err)
+	}
+
+	if err = c.Confirm(false); err != nil {
+		return nil, fmt.Errorf("confirm.select: %v", err)
+	}
+
+	return &confirmedPublisher{channel: c, confirms: c.NotifyPublish(make(chan amqp.Confirmation, 1))}, nil
+}
+
+// publish sends a message and waits for the broker to confirm it
+func (p *confirmedPublisher) publish(exchange string, key string, msg amqp.Publishing) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.channel.Publish(exchange, key, false, false, msg); err != nil {
+		return err
+	}
+
+	if confirmation, ok := <-p.confirms; !ok || !confirmation.Ack {
+		return errPublishNotConfirmed
+	}
+
+	return nil
+}
+
+// rejectDelivery handles a message whose handler failed. Without a retry policy the message is requeued,
+// otherwise it is scheduled for a delayed retry or dead lettered once all attempts have failed.
+// Messages failing with a permanent error are never retried: they are dead lettered at once, or acknowledged without a retry policy.
+// A message is only acknowledged once the broker confirmed its retried or dead lettered copy, otherwise it is requeued.
+// The returned value reports whether the failure is final, that is the message was dropped or dead lettered rather than redelivered
+func rejectDelivery(c *consumer, message amqp.Delivery, exchange string, queue string, policy *RetryPolicy, deadLetter cqrs.DeadLetter) bool {
+	if policy == nil && deadLetter.Permanent {
+		cqrs.PackageLogger().Debugf("RabbitMQ: Dropping permanently failed message: %v", deadLetter.Error)
+		if err := message.Ack(false); err != nil {
+			cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
+		}
+
+		return true
+	}
+
+	if policy == nil || c.retries == nil {
+		if err := message.Reject(true); err != nil {
+			cqrs.PackageLogger().Debugf("ERROR: Message reject returned error: %v\n", err)
+		}
+
+		return false
+	}
+
+	attempts := deliveryAttempts(message) + 1
+	msg := retryPublishing(message, attempts, deadLetter.Error)
+
+	var err error
+	final := attempts >= policy.MaxAttempts || deadLetter.Permanent
+	if !final {
+		msg.Expiration = strconv.FormatInt(int64(policy.Delay/time.Millisecond), 10)
+		if err = c.retries.publish("", retryQueueName(queue), msg); err == nil {
+			metricsMessagesRetried.WithLabelValues(queue).Inc()
+		}
+	} else {
+		cqrs.PackageLogger().Debugf("RabbitMQ: Dead lettering message after %d attempts: %v", attempts, deadLetter.Error)
+		if err = c.retries.publish(policy.deadLetterExchange(exchange), queue, msg); err == nil {
+			metricsMessagesDeadLettered.WithLabelValues(queue).Inc()
+			if policy.DeadLetterStore != nil {
+				deadLetter.Attempts = attempts
+				if errSave := policy.DeadLetterStore.SaveDeadLetter(deadLetter); errSave != nil {
+					cqrs.PackageLogger().Debugf("ERROR: Saving dead letter returned error: %v\n", errSave)
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: Message retry publish returned error: %v\n", err)
+		if err = message.Nack(false, true); err != nil {
+			cqrs.PackageLogger().Debugf("ERROR: Message nack returned error: %v\n", err)
+		}
+
+		return false
+	}
+
+	if err = message.Ack(false); err != nil {
+		cqrs.PackageLogger().Debugf("ERROR: Message ack returned error: %v\n", err)
+	}
+
+	return final
+}
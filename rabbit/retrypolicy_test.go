@@ -0,0 +1,66 @@
+package rabbit
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestDeliveryAttempts(t *testing.T) {
+	for _, test := range []struct {
+		headers  amqp.Table
+		attempts int
+	}{
+		{nil, 0},
+		{amqp.Table{attemptsHeader: int32(2)}, 2},
+		{amqp.Table{attemptsHeader: int64(3)}, 3},
+		{amqp.Table{attemptsHeader: 4}, 4},
+		{amqp.Table{attemptsHeader: "5"}, 0},
+	} {
+		if attempts := deliveryAttempts(amqp.Delivery{Headers: test.headers}); attempts != test.attempts {
+			t.Fatal("Expected", test.attempts, "attempts for", test.headers, "but got", attempts)
+		}
+	}
+}
+
+func TestRetryPublishing(t *testing.T) {
+	message := amqp.Delivery{
+		Headers:       amqp.Table{"x-custom": "value", attemptsHeader: int32(1)},
+		ContentType:   "text/plain",
+		CorrelationId: "mid:1",
+		ReplyTo:       "amq.gen-reply",
+		Body:          []byte("body"),
+	}
+
+	msg := retryPublishing(message, 2, "handler failed")
+	if msg.Headers[attemptsHeader] != int32(2) || msg.Headers[errorHeader] != "handler failed" || msg.Headers["x-custom"] != "value" {
+		t.Fatal("Expected the attempts, last error and existing headers to be recorded but got", msg.Headers)
+	}
+
+	if message.Headers[attemptsHeader] != int32(1) {
+		t.Fatal("Expected the delivery headers to be left unchanged but got", message.Headers)
+	}
+
+	if msg.DeliveryMode != amqp.Persistent || msg.CorrelationId != "mid:1" || msg.ReplyTo != "amq.gen-reply" || string(msg.Body) != "body" {
+		t.Fatal("Expected the delivery to be republished unchanged but got", msg)
+	}
+
+	if deliveryAttempts(amqp.Delivery{Headers: msg.Headers}) != 2 {
+		t.Fatal("Expected the republished attempts to be read back")
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	if policy := (RetryPolicy{}).withDefaults(); policy.MaxAttempts != DefaultRetryMaxAttempts {
+		t.Fatal("Expected a zero value policy to retry", DefaultRetryMaxAttempts, "times but got", policy.MaxAttempts)
+	}
+
+	if policy := (RetryPolicy{MaxAttempts: 1}).withDefaults(); policy.MaxAttempts != 1 {
+		t.Fatal("Expected an explicit maximum number of attempts to be kept but got", policy.MaxAttempts)
+	}
+
+	policy := RetryPolicy{}
+	if policy.deadLetterExchange("commands") != "commands.deadletter" || retryQueueName("q") != "q.retry" || deadLetterQueueName("q") != "q.deadletter" {
+		t.Fatal("Unexpected retry topology names")
+	}
+}
@@ -0,0 +1,140 @@
+package rabbit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type routedCommand struct{}
+
+func TestTypeRoutingKey(t *testing.T) {
+	if key := typeRoutingKey("*rabbit.routedCommand"); key != "rabbit.routedCommand" {
+		t.Fatal("Expected the pointer prefix to be dropped but got", key)
+	}
+
+	if key := CommandTypeRoutingKey(&routedCommand{}); key != "rabbit.routedCommand" {
+		t.Fatal("Expected the command type name as routing key but got", key)
+	}
+}
+
+func TestCommandRoutingKey(t *testing.T) {
+	bus := &CommandBus{name: "commands"}
+	command := cqrs.CreateCommand(routedCommand{})
+	if key := bus.routingKey(command); key != "commands" {
+		t.Fatal("Expected commands to be routed by queue name but got", key)
+	}
+
+	bus.SetCommandTypeRouting()
+	if key := bus.routingKey(command); key != "rabbit.routedCommand" {
+		t.Fatal("Expected commands to be routed by type but got", key)
+	}
+}
+
+func TestCommandQueueBindings(t *testing.T) {
+	bus := &CommandBus{name: "commands"}
+	if bindings := bus.queueBindings(); !reflect.DeepEqual(bindings, []string{"commands"}) {
+		t.Fatal("Expected the queue to be bound by name but got", bindings)
+	}
+
+	if stale := bus.staleBindings(); len(stale) != 0 {
+		t.Fatal("Expected no stale bindings without type routing but got", stale)
+	}
+
+	bus.SetCommandTypeRouting()
+	if bindings := bus.queueBindings(); !reflect.DeepEqual(bindings, []string{"#"}) {
+		t.Fatal("Expected the queue to receive every command type but got", bindings)
+	}
+
+	bus.SetCommandTypeRouting("rabbit.routedCommand")
+	if bindings := bus.queueBindings(); !reflect.DeepEqual(bindings, []string{"rabbit.routedCommand"}) {
+		t.Fatal("Expected the configured bindings but got", bindings)
+	}
+
+	if stale := bus.staleBindings(); !reflect.DeepEqual(stale, []string{"commands"}) {
+		t.Fatal("Expected the binding by queue name to be removed but got", stale)
+	}
+
+	bus.SetCommandTypeRouting("commands", "rabbit.routedCommand")
+	if stale := bus.staleBindings(); len(stale) != 0 {
+		t.Fatal("Expected a configured binding by queue name to be kept but got", stale)
+	}
+}
+
+type routedEvent struct{}
+
+func TestEventRoutingKey(t *testing.T) {
+	bus := &EventBus{name: "events"}
+	event := cqrs.VersionedEvent{EventType: "rabbit.routedEvent", SourceID: "source:1"}
+	if key, kind := bus.routingKey(event), bus.exchangeKind(); key != "events" || kind != "fanout" {
+		t.Fatal("Expected events to be fanned out by queue name but got", key, kind)
+	}
+
+	bus.setEventTypeRouting(nil)
+	if key, kind := bus.routingKey(event), bus.exchangeKind(); key != "rabbit.routedEvent" || kind != "topic" {
+		t.Fatal("Expected events to be routed by type on a topic exchange but got", key, kind)
+	}
+
+	if key := EventTypeRoutingKey(&routedEvent{}); key != "rabbit.routedEvent" {
+		t.Fatal("Expected the event type name as routing key but got", key)
+	}
+
+	bus.setEventTypeRouting(func(event cqrs.VersionedEvent) string { return "source." + event.SourceID }, "source.#")
+	if key := bus.routingKey(event); key != "source.source:1" {
+		t.Fatal("Expected events to be routed by the key function but got", key)
+	}
+}
+
+func TestEventQueueBindings(t *testing.T) {
+	bus := &EventBus{name: "events"}
+	if bindings := bus.queueBindings([]string{"rabbit.routedEvent"}); !reflect.DeepEqual(bindings, []string{"events"}) {
+		t.Fatal("Expected the queue to be bound by name but got", bindings)
+	}
+
+	bus.setEventTypeRouting(nil)
+	if bindings := bus.queueBindings([]string{"*rabbit.routedEvent", "rabbit.otherEvent"}); !reflect.DeepEqual(bindings, []string{"rabbit.routedEvent", "rabbit.otherEvent"}) {
+		t.Fatal("Expected the queue to be bound to the handled event types but got", bindings)
+	}
+
+	if bindings := bus.queueBindings(nil); !reflect.DeepEqual(bindings, []string{"#"}) {
+		t.Fatal("Expected a global handler to bind every event but got", bindings)
+	}
+
+	bus.setEventTypeRouting(nil, "rabbit.#")
+	if bindings := bus.queueBindings([]string{"rabbit.routedEvent"}); !reflect.DeepEqual(bindings, []string{"rabbit.#"}) {
+		t.Fatal("Expected the configured bindings but got", bindings)
+	}
+}
+
+func TestEventRoutingWithCustomKeyRequiresBindings(t *testing.T) {
+	bus := &EventBus{name: "events"}
+	bus.setEventTypeRouting(func(event cqrs.VersionedEvent) string { return event.SourceID })
+	if err := bus.ReceiveEvents(cqrs.VersionedEventReceiverOptions{}); err != ErrEventRoutingBindingsRequired {
+		t.Fatal("Expected ErrEventRoutingBindingsRequired but got", err)
+	}
+}
+
+func TestBindEventTypesWithStaticBindings(t *testing.T) {
+	// Without type routing or with binding patterns the queue bindings never change, so no channel is opened
+	bus := &EventBus{name: "events"}
+	if err := bus.BindEventTypes([]string{"rabbit.routedEvent"}); err != nil {
+		t.Fatal(err)
+	}
+
+	bus.setEventTypeRouting(nil, "rabbit.#")
+	if err := bus.BindEventTypes([]string{"rabbit.routedEvent"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBindingChanges(t *testing.T) {
+	bind, unbind := bindingChanges([]string{"a", "b"}, []string{"b", "c"})
+	if !reflect.DeepEqual(bind, []string{"c"}) || !reflect.DeepEqual(unbind, []string{"a"}) {
+		t.Fatal("Expected to bind c and unbind a but got", bind, unbind)
+	}
+
+	if bind, unbind := bindingChanges([]string{"a"}, []string{"a"}); len(bind) != 0 || len(unbind) != 0 {
+		t.Fatal("Expected no changes but got", bind, unbind)
+	}
+}
@@ -0,0 +1,94 @@
+package cqrs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReceiverErrorKind classifies the errors reported while receiving commands and events
+type ReceiverErrorKind string
+
+const (
+	// ReceiverErrorDeserialization is reported when a received message cannot be deserialized
+	ReceiverErrorDeserialization ReceiverErrorKind = "deserialization"
+	// ReceiverErrorUnknownType is reported when a received message has a type missing from the type registry
+	ReceiverErrorUnknownType ReceiverErrorKind = "unknown_type"
+	// ReceiverErrorHandler is reported when dispatching a received message to its handlers fails
+	ReceiverErrorHandler ReceiverErrorKind = "handler"
+	// ReceiverErrorConnection is reported when a receiver loses its connection to the message broker
+	ReceiverErrorConnection ReceiverErrorKind = "connection"
+	// ReceiverErrorCheckpoint is reported when the checkpoint of a subscriber is stuck behind an event that was not redelivered
+	ReceiverErrorCheckpoint ReceiverErrorKind = "checkpoint"
+	// ReceiverErrorUnknown is reported for errors a receiver did not classify
+	ReceiverErrorUnknown ReceiverErrorKind = "unknown"
+)
+
+// ReceiverError is an error reported while receiving commands or events
+type ReceiverError struct {
+	Kind        ReceiverErrorKind
+	MessageType string
+	MessageID   string
+	Err         error
+}
+
+// NewReceiverError is a constructor for the ReceiverError. Receivers send receiver errors on the Error channel of their options
+func NewReceiverError(kind ReceiverErrorKind, messageType string, err error) *ReceiverError {
+	return &ReceiverError{Kind: kind, MessageType: messageType, Err: err}
+}
+
+func (e *ReceiverError) Error() string {
+	if e.MessageType == "" {
+		return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+	}
+
+	return fmt.Sprintf("%s error receiving %s: %v", e.Kind, e.MessageType, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ReceiverError) Unwrap() error {
+	return e.Err
+}
+
+// ReceiverErrorObserver is called with every error reported while receiving commands or events.
+// Observers may be called concurrently from different go routines and must not block
+type ReceiverErrorObserver func(*ReceiverError)
+
+// asReceiverError classifies errors reported by receivers that are not already receiver errors as unknown
+func asReceiverError(err error) *ReceiverError {
+	var receiverErr *ReceiverError
+	if errors.As(err, &receiverErr) {
+		return receiverErr
+	}
+
+	return NewReceiverError(ReceiverErrorUnknown, "", err)
+}
+
+// PermanentError marks a failure that redelivering the message cannot resolve, such as a command failing validation.
+// Receivers settle a message failing with a permanent error once, acknowledging or dead lettering it instead of retrying it
+type PermanentError struct {
+	Err error
+}
+
+// NewPermanentError marks an error as permanent. Nil and errors that are already permanent are returned unchanged
+func NewPermanentError(err error) error {
+	if err == nil || IsPermanent(err) {
+		return err
+	}
+
+	return &PermanentError{err}
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether an error, or any error it wraps, is a PermanentError
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
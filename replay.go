@@ -0,0 +1,125 @@
+package cqrs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultReplayProgressInterval is the number of events replayed between progress reports when none is configured
+const DefaultReplayProgressInterval = 100
+
+// ReplayOptions configures a replay of the integration event log
+type ReplayOptions struct {
+	// Handlers receive every replayed event in the order of the log
+	Handlers []VersionedEventHandler
+	// Reset is called before replaying, for example to wipe the read models being rebuilt. It is not called in dry run mode
+	Reset func() error
+	// Progress is called every ProgressInterval replayed events and once the replay has finished
+	Progress         func(ReplayProgress)
+	ProgressInterval int
+	// Rate limits the number of events replayed per second. Zero replays as fast as possible
+	Rate int
+	// DryRun reads the log and reports progress without calling Reset or the handlers
+	DryRun bool
+	// ContinueOnError keeps replaying when a handler fails instead of stopping at the first failure
+	ContinueOnError bool
+}
+
+// ReplayProgress reports how far a replay has progressed
+type ReplayProgress struct {
+	Total    int
+	Replayed int
+	Failed   int
+	Elapsed  time.Duration
+}
+
+// ReplayEvents streams all events stored in the integration event log through the handlers of the options.
+// Replayed events are marked as replayed so handlers can suppress side effects such as sending emails.
+// The replay stops when the context is done
+func ReplayEvents(ctx context.Context, log VersionedEventPublicationLogger, options ReplayOptions) (ReplayProgress, error) {
+	start := time.Now()
+	events, err := log.AllIntegrationEventsEverPublished()
+	if err != nil {
+		return ReplayProgress{}, err
+	}
+
+	progress := ReplayProgress{Total: len(events)}
+	report := func() {
+		progress.Elapsed = time.Since(start)
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+
+	if !options.DryRun && options.Reset != nil {
+		if err := options.Reset(); err != nil {
+			return progress, fmt.Errorf("reset: %v", err)
+		}
+	}
+
+	interval := options.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultReplayProgressInterval
+	}
+
+	var throttle <-chan time.Time
+	if options.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(options.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	var firstErr error
+	for _, event := range events {
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				report()
+				return progress, ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			report()
+			return progress, ctx.Err()
+		}
+
+		if !options.DryRun {
+			event.Replayed = true
+			if err := replayEvent(event, options.Handlers); err != nil {
+				progress.Failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+
+				if !options.ContinueOnError {
+					report()
+					return progress, err
+				}
+			}
+		}
+
+		progress.Replayed++
+		if progress.Replayed%interval == 0 {
+			report()
+		}
+	}
+
+	report()
+	if firstErr != nil {
+		return progress, fmt.Errorf("%d events failed to replay, first error: %w", progress.Failed, firstErr)
+	}
+
+	return progress, nil
+}
+
+// replayEvent passes a replayed event to every handler, stopping at the first failing handler
+func replayEvent(event VersionedEvent, handlers []VersionedEventHandler) error {
+	for _, handler := range handlers {
+		if err := handler(event); err != nil {
+			return fmt.Errorf("replaying event %s: %w", event.ID, &HandlerError{handlerName(handler), err})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package cqrs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+func TestReplayEvents(t *testing.T) {
+	log := cqrs.NewInMemoryEventStreamRepository()
+	for _, message := range []string{"first", "second", "third"} {
+		_ = log.SaveIntegrationEvent(cqrs.VersionedEvent{ID: message, Created: time.Now(), Event: SampleMessageReceivedEvent{message}})
+	}
+
+	var replayed []string
+	reset := false
+	handler := func(event cqrs.VersionedEvent) error {
+		if !event.Replayed {
+			return errors.New("expected event to be marked as replayed")
+		}
+
+		replayed = append(replayed, event.ID)
+		return nil
+	}
+
+	var reports []cqrs.ReplayProgress
+	options := cqrs.ReplayOptions{
+		Handlers:         []cqrs.VersionedEventHandler{handler},
+		Reset:            func() error { reset = true; return nil },
+		Progress:         func(progress cqrs.ReplayProgress) { reports = append(reports, progress) },
+		ProgressInterval: 2,
+		DryRun:           true}
+
+	progress, err := cqrs.ReplayEvents(context.Background(), log, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reset || len(replayed) != 0 || progress.Replayed != 3 {
+		t.Fatal("Expected a dry run to read every event without resetting or calling handlers", progress)
+	}
+
+	reports = nil
+	options.DryRun = false
+	options.Rate = 1000
+	progress, err = cqrs.ReplayEvents(context.Background(), log, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reset || len(replayed) != 3 || replayed[0] != "first" || replayed[2] != "third" {
+		t.Fatal("Expected every event to be replayed in order after resetting but got", replayed)
+	}
+
+	if len(reports) != 2 || reports[0].Replayed != 2 || reports[1].Replayed != 3 || reports[1].Total != 3 {
+		t.Fatal("Expected progress to be reported every two events and once finished but got", reports)
+	}
+
+	failing := errors.New("read model unavailable")
+	options.Handlers = []cqrs.VersionedEventHandler{func(event cqrs.VersionedEvent) error { return failing }}
+	options.ContinueOnError = true
+	progress, err = cqrs.ReplayEvents(context.Background(), log, options)
+	if !errors.Is(err, failing) || progress.Failed != 3 {
+		t.Fatal("Expected every failure to be counted and reported but got", progress, err)
+	}
+}
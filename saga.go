@@ -0,0 +1,334 @@
+package cqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSagaNotFound is returned by a saga store when no saga is stored under the requested ID
+var ErrSagaNotFound = errors.New("saga not found")
+
+// ErrSagaConcurrency is returned by a saga store when a saga was saved by someone else since it was loaded
+var ErrSagaConcurrency = errors.New("saga was modified concurrently")
+
+// ErrSagaAlreadyRegistered is returned when registering two saga types with the same name
+var ErrSagaAlreadyRegistered = errors.New("saga already registered")
+
+// DefaultSagaRetries is the number of times handling an event is retried after a saga concurrency conflict
+var DefaultSagaRetries = 3
+
+// DefaultSagaProcessedEvents is the number of handled event IDs a saga instance remembers to ignore redelivered events
+var DefaultSagaProcessedEvents = 1000
+
+// SagaState is the persisted state of a saga instance.
+// Version is incremented on every save and is used to detect concurrent modifications.
+// PendingCommands is the outbox of commands issued by the saga that were not published yet
+type SagaState struct {
+	ID              string          `json:"id"`
+	SagaType        string          `json:"sagaType"`
+	Version         int             `json:"version"`
+	Completed       bool            `json:"completed"`
+	Data            json.RawMessage `json:"data"`
+	Updated         time.Time       `json:"updated"`
+	ProcessedEvents []string        `json:"processedEvents,omitempty"`
+	PendingCommands []Command       `json:"pendingCommands,omitempty"`
+}
+
+// processed reports whether the saga instance already handled the event with the given ID
+func (s SagaState) processed(eventID string) bool {
+	for _, processed := range s.ProcessedEvents {
+		if processed == eventID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SagaStore persists the state of saga instances
+type SagaStore interface {
+	// GetSaga returns the state of a saga instance or ErrSagaNotFound
+	GetSaga(sagaType string, id string) (SagaState, error)
+	// SaveSaga persists the state of a saga instance, returning ErrSagaConcurrency when the stored version differs from expectedVersion
+	SaveSaga(state SagaState, expectedVersion int) error
+}
+
+// SagaHandler advances a saga instance in response to an event
+type SagaHandler func(*SagaContext, VersionedEvent) error
+
+// SagaContext gives a saga handler access to the state of the saga instance handling an event
+type SagaContext struct {
+	ID        string
+	State     interface{}
+	commands  []Command
+	completed bool
+}
+
+// Send issues a command once the saga state is saved. The command carries the saga's correlation ID
+func (c *SagaContext) Send(body interface{}) {
+	c.commands = append(c.commands, CreateCommandWithCorrelationID(body, c.ID))
+}
+
+// Complete marks the saga as completed. Events received for a completed saga are ignored
+func (c *SagaContext) Complete() {
+	c.completed = true
+}
+
+// Completed returns whether the saga was marked as completed
+func (c *SagaContext) Completed() bool {
+	return c.completed
+}
+
+// SagaType describes a kind of saga: the events starting a new instance, the events advancing an instance and its state.
+// Saga instances are keyed by the correlation ID of the events they handle
+type SagaType struct {
+	name     string
+	newState func() interface{}
+	starters map[reflect.Type]SagaHandler
+	handlers map[reflect.Type]SagaHandler
+}
+
+// NewSagaType is a constructor for a SagaType. newState returns a pointer to the zero state of a new saga instance
+func NewSagaType(name string, newState func() interface{}) *SagaType {
+	return &SagaType{name: name, newState: newState, starters: make(map[reflect.Type]SagaHandler), handlers: make(map[reflect.Type]SagaHandler)}
+}
+
+// Name returns the name of the saga type
+func (s *SagaType) Name() string {
+	return s.name
+}
+
+// StartedBy registers a handler for an event starting a new saga instance.
+// The event is ignored when an instance with the same correlation ID already exists
+func (s *SagaType) StartedBy(event interface{}, handler SagaHandler) {
+	s.starters[reflect.TypeOf(event)] = handler
+}
+
+// Handles registers a handler for an event advancing an existing saga instance.
+// The event is ignored when no instance with its correlation ID exists
+func (s *SagaType) Handles(event interface{}, handler SagaHandler) {
+	s.handlers[reflect.TypeOf(event)] = handler
+}
+
+// eventTypes returns the event types handled by the saga type
+func (s *SagaType) eventTypes() []reflect.Type {
+	var eventTypes []reflect.Type
+	for eventType := range s.starters {
+		eventTypes = append(eventTypes, eventType)
+	}
+
+	for eventType := range s.handlers {
+		if _, ok := s.starters[eventType]; !ok {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+
+	return eventTypes
+}
+
+// SagaManager routes events to the saga instances they belong to, persists the saga state and publishes the commands sagas issue.
+// Issued commands are saved with the saga state and published from there, so commands that failed to publish are published again
+// with the same message ID when the saga next receives an event. Redelivered events are ignored and events conflicting with a
+// concurrent update of the same saga are retried
+type SagaManager struct {
+	store     SagaStore
+	publisher CommandPublisher
+	retries   int
+
+	lock  sync.RWMutex
+	sagas map[string]*SagaType
+}
+
+// NewSagaManager is a constructor for the SagaManager
+func NewSagaManager(store SagaStore, publisher CommandPublisher) *SagaManager {
+	return &SagaManager{store: store, publisher: publisher, retries: DefaultSagaRetries, sagas: make(map[string]*SagaType)}
+}
+
+// SetRetries sets the number of times handling an event is retried after a saga concurrency conflict
+func (m *SagaManager) SetRetries(retries int) {
+	m.retries = retries
+}
+
+// RegisterSaga registers a saga type with the manager
+func (m *SagaManager) RegisterSaga(saga *SagaType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.sagas[saga.name]; ok {
+		return fmt.Errorf("%w for %s", ErrSagaAlreadyRegistered, saga.name)
+	}
+
+	m.sagas[saga.name] = saga
+	return nil
+}
+
+// Subscribe registers the manager with an event dispatcher for every event type handled by the registered sagas.
+// The returned subscription removes the registrations again
+func (m *SagaManager) Subscribe(dispatcher VersionedEventDispatcher) Subscription {
+	registered := make(map[reflect.Type]bool)
+	var subscriptions []Subscription
+
+	m.lock.RLock()
+	for _, saga := range m.sagas {
+		for _, eventType := range saga.eventTypes() {
+			if registered[eventType] {
+				continue
+			}
+
+			registered[eventType] = true
+			subscriptions = append(subscriptions, dispatcher.RegisterEventHandler(reflect.Zero(eventType).Interface(), m.HandleEvent))
+		}
+	}
+	m.lock.RUnlock()
+
+	return newSubscription(func() {
+		for _, subscription := range subscriptions {
+			subscription.Unsubscribe()
+		}
+	})
+}
+
+// HandleEvent routes an event to every registered saga handling its type
+func (m *SagaManager) HandleEvent(event VersionedEvent) error {
+	m.lock.RLock()
+	sagas := make([]*SagaType, 0, len(m.sagas))
+	for _, saga := range m.sagas {
+		sagas = append(sagas, saga)
+	}
+	m.lock.RUnlock()
+
+	sort.Slice(sagas, func(i, j int) bool { return sagas[i].name < sagas[j].name })
+
+	var firstErr error
+	for _, saga := range sagas {
+		if err := m.handleSagaEvent(saga, event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// handleSagaEvent handles an event for a saga type, retrying when the saga was saved concurrently
+func (m *SagaManager) handleSagaEvent(saga *SagaType, event VersionedEvent) error {
+	eventType := reflect.TypeOf(event.Event)
+	starter, starts := saga.starters[eventType]
+	handler, handles := saga.handlers[eventType]
+	if !starts && !handles {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := m.advanceSaga(saga, event, starter, handler)
+		if !errors.Is(err, ErrSagaConcurrency) || attempt >= m.retries {
+			return err
+		}
+
+		PackageLogger().Debugf("SagaManager.Conflict: %s %s retrying %s", saga.name, event.CorrelationID, event.EventType)
+		metricsSagaConflicts.WithLabelValues(saga.name).Inc()
+	}
+}
+
+// advanceSaga loads the saga instance an event belongs to, runs the matching handler, saves the state and publishes the issued commands
+func (m *SagaManager) advanceSaga(saga *SagaType, event VersionedEvent, starter SagaHandler, handler SagaHandler) error {
+	id := event.CorrelationID
+	state, err := m.store.GetSaga(saga.name, id)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrSagaNotFound) {
+		return err
+	}
+
+	run := handler
+	switch {
+	case !exists && starter == nil:
+		PackageLogger().Debugf("SagaManager.Ignored: %s %s not started for %s", saga.name, id, event.EventType)
+		return nil
+	case !exists:
+		run = starter
+		state = SagaState{ID: id, SagaType: saga.name}
+	case state.processed(event.ID):
+		PackageLogger().Debugf("SagaManager.Ignored: %s %s already handled %s %s", saga.name, id, event.EventType, event.ID)
+		return m.publishPending(state)
+	case state.Completed:
+		PackageLogger().Debugf("SagaManager.Ignored: %s %s completed for %s", saga.name, id, event.EventType)
+		return m.publishPending(state)
+	case handler == nil:
+		PackageLogger().Debugf("SagaManager.Ignored: %s %s already started for %s", saga.name, id, event.EventType)
+		return m.publishPending(state)
+	}
+
+	ctx := &SagaContext{ID: id, State: saga.newState()}
+	if len(state.Data) > 0 {
+		if err := json.Unmarshal(state.Data, ctx.State); err != nil {
+			return err
+		}
+	}
+
+	if err := run(ctx, event); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(ctx.State)
+	if err != nil {
+		return err
+	}
+
+	processed := append(append([]string(nil), state.ProcessedEvents...), event.ID)
+	if len(processed) > DefaultSagaProcessedEvents {
+		processed = processed[len(processed)-DefaultSagaProcessedEvents:]
+	}
+
+	expectedVersion := state.Version
+	state.Version++
+	state.Data = data
+	state.Completed = ctx.completed
+	state.Updated = time.Now().UTC()
+	state.ProcessedEvents = processed
+	state.PendingCommands = append(append([]Command(nil), state.PendingCommands...), ctx.commands...)
+	if err := m.store.SaveSaga(state, expectedVersion); err != nil {
+		return err
+	}
+
+	if !exists {
+		metricsSagasStarted.WithLabelValues(saga.name).Inc()
+	}
+
+	if ctx.completed {
+		metricsSagasCompleted.WithLabelValues(saga.name).Inc()
+	}
+
+	return m.publishPending(state)
+}
+
+// publishPending publishes the outbox of a saved saga state and saves the state again without it.
+// When the state was saved concurrently in between, the outbox is left to the concurrent update, which may publish it again
+// under the same message IDs
+func (m *SagaManager) publishPending(state SagaState) error {
+	if len(state.PendingCommands) == 0 {
+		return nil
+	}
+
+	if err := m.publisher.PublishCommands(state.PendingCommands); err != nil {
+		return err
+	}
+
+	expectedVersion := state.Version
+	state.Version++
+	state.PendingCommands = nil
+	state.Updated = time.Now().UTC()
+	if err := m.store.SaveSaga(state, expectedVersion); err != nil {
+		if errors.Is(err, ErrSagaConcurrency) {
+			PackageLogger().Debugf("SagaManager.Conflict: %s %s leaving published commands to the concurrent update", state.SagaType, state.ID)
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package cqrs_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type OrderPlacedEvent struct {
+	OrderID string
+}
+
+type StockReservedEvent struct {
+	OrderID string
+}
+
+type ReserveStockCommand struct {
+	OrderID string
+}
+
+type ShipOrderCommand struct {
+	OrderID string
+}
+
+type orderSagaState struct {
+	OrderID       string
+	StockReserved bool
+}
+
+// conflictingSagaStore saves a concurrent update of the saga before the next save once a conflict was requested
+type conflictingSagaStore struct {
+	*cqrs.InMemorySagaStore
+	conflict   bool
+	conflicted bool
+}
+
+func (s *conflictingSagaStore) SaveSaga(state cqrs.SagaState, expectedVersion int) error {
+	if s.conflict && expectedVersion > 0 {
+		s.conflict = false
+		s.conflicted = true
+		concurrent, err := s.InMemorySagaStore.GetSaga(state.SagaType, state.ID)
+		if err != nil {
+			return err
+		}
+
+		concurrent.Version++
+		if err := s.InMemorySagaStore.SaveSaga(concurrent, expectedVersion); err != nil {
+			return err
+		}
+	}
+
+	return s.InMemorySagaStore.SaveSaga(state, expectedVersion)
+}
+
+func newOrderSaga() *cqrs.SagaType {
+	saga := cqrs.NewSagaType("order", func() interface{} { return &orderSagaState{} })
+	saga.StartedBy(OrderPlacedEvent{}, func(ctx *cqrs.SagaContext, event cqrs.VersionedEvent) error {
+		state := ctx.State.(*orderSagaState)
+		state.OrderID = event.Event.(OrderPlacedEvent).OrderID
+		ctx.Send(ReserveStockCommand{state.OrderID})
+		return nil
+	})
+
+	saga.Handles(StockReservedEvent{}, func(ctx *cqrs.SagaContext, event cqrs.VersionedEvent) error {
+		state := ctx.State.(*orderSagaState)
+		state.StockReserved = true
+		ctx.Send(ShipOrderCommand{state.OrderID})
+		ctx.Complete()
+		return nil
+	})
+
+	return saga
+}
+
+func sagaEvent(correlationID string, event interface{}) cqrs.VersionedEvent {
+	return cqrs.VersionedEvent{ID: cqrs.NewUUIDString(), CorrelationID: correlationID, Event: event}
+}
+
+func TestSagaManagerAdvancesSagas(t *testing.T) {
+	store := cqrs.NewInMemorySagaStore()
+	publisher := &recordingCommandPublisher{}
+	manager := cqrs.NewSagaManager(store, publisher)
+	if err := manager.RegisterSaga(newOrderSaga()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.RegisterSaga(newOrderSaga()); !errors.Is(err, cqrs.ErrSagaAlreadyRegistered) {
+		t.Fatal("Expected a duplicate saga to be rejected but got", err)
+	}
+
+	dispatcher := cqrs.NewVersionedEventDispatcher()
+	subscription := manager.Subscribe(dispatcher)
+	defer subscription.Unsubscribe()
+
+	if err := dispatcher.DispatchEvent(sagaEvent("cid:unknown", StockReservedEvent{"order-0"})); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetSaga("order", "cid:unknown"); !errors.Is(err, cqrs.ErrSagaNotFound) {
+		t.Fatal("Expected an event for a saga that was never started to be ignored but got", err)
+	}
+
+	for _, event := range []cqrs.VersionedEvent{
+		sagaEvent("cid:1", OrderPlacedEvent{"order-1"}),
+		sagaEvent("cid:1", OrderPlacedEvent{"order-1"}),
+		sagaEvent("cid:1", StockReservedEvent{"order-1"}),
+		sagaEvent("cid:1", StockReservedEvent{"order-1"})} {
+		if err := dispatcher.DispatchEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, err := store.GetSaga("order", "cid:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !state.Completed || state.Version != 4 || len(state.PendingCommands) != 0 {
+		t.Fatal("Expected the saga to be completed after two updates each clearing its outbox but got", state)
+	}
+
+	if len(publisher.commands) != 2 {
+		t.Fatal("Expected two commands but got", publisher.commands)
+	}
+
+	if _, ok := publisher.commands[0].Body.(ReserveStockCommand); !ok || publisher.commands[0].CorrelationID != "cid:1" {
+		t.Fatal("Expected the saga to reserve stock but got", publisher.commands[0])
+	}
+
+	if _, ok := publisher.commands[1].Body.(ShipOrderCommand); !ok {
+		t.Fatal("Expected the saga to ship the order but got", publisher.commands[1])
+	}
+}
+
+func TestSagaManagerRetriesConcurrencyConflicts(t *testing.T) {
+	store := &conflictingSagaStore{InMemorySagaStore: cqrs.NewInMemorySagaStore()}
+	publisher := &recordingCommandPublisher{}
+	manager := cqrs.NewSagaManager(store, publisher)
+	if err := manager.RegisterSaga(newOrderSaga()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.HandleEvent(sagaEvent("cid:1", OrderPlacedEvent{"order-1"})); err != nil {
+		t.Fatal(err)
+	}
+
+	store.conflict = true
+	if err := manager.HandleEvent(sagaEvent("cid:1", StockReservedEvent{"order-1"})); err != nil {
+		t.Fatal(err)
+	}
+
+	state, _ := store.GetSaga("order", "cid:1")
+	if !store.conflicted || !state.Completed || state.Version != 5 {
+		t.Fatal("Expected the conflicting update to be retried but got", state)
+	}
+
+	if len(publisher.commands) != 2 {
+		t.Fatal("Expected commands to be published once per saved update but got", publisher.commands)
+	}
+
+	manager.SetRetries(0)
+	if err := manager.HandleEvent(sagaEvent("cid:2", OrderPlacedEvent{"order-2"})); err != nil {
+		t.Fatal(err)
+	}
+
+	store.conflict = true
+	if err := manager.HandleEvent(sagaEvent("cid:2", StockReservedEvent{"order-2"})); !errors.Is(err, cqrs.ErrSagaConcurrency) {
+		t.Fatal("Expected the conflict to be returned without retries but got", err)
+	}
+}
+
+// failingCommandPublisher fails publishing until it is told to succeed
+type failingCommandPublisher struct {
+	recordingCommandPublisher
+	failing bool
+}
+
+func (p *failingCommandPublisher) PublishCommands(commands []cqrs.Command) error {
+	if p.failing {
+		return errors.New("bus unavailable")
+	}
+
+	return p.recordingCommandPublisher.PublishCommands(commands)
+}
+
+func TestSagaManagerPublishesOutboxOnceAndIgnoresRedeliveries(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	typeRegistry.RegisterType(ReserveStockCommand{})
+	path := filepath.Join(t.TempDir(), "sagas.json")
+	store, err := cqrs.NewFileSagaStore(path, typeRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := &failingCommandPublisher{failing: true}
+	manager := cqrs.NewSagaManager(store, publisher)
+	if err := manager.RegisterSaga(newOrderSaga()); err != nil {
+		t.Fatal(err)
+	}
+
+	placed := sagaEvent("cid:1", OrderPlacedEvent{"order-1"})
+	if err := manager.HandleEvent(placed); err == nil {
+		t.Fatal("Expected the publishing failure to be returned")
+	}
+
+	state, err := store.GetSaga("order", "cid:1")
+	if err != nil || len(state.PendingCommands) != 1 {
+		t.Fatal("Expected the unpublished command to be kept in the outbox but got", state, err)
+	}
+
+	// The outbox survives reopening the store and is published when the event is redelivered, without handling it again
+	reopened, err := cqrs.NewFileSagaStore(path, typeRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager = cqrs.NewSagaManager(reopened, publisher)
+	if err := manager.RegisterSaga(newOrderSaga()); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher.failing = false
+	for i := 0; i < 2; i++ {
+		if err := manager.HandleEvent(placed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(publisher.commands) != 1 || publisher.commands[0].MessageID != state.PendingCommands[0].MessageID {
+		t.Fatal("Expected the outbox to be published once under its message ID but got", publisher.commands)
+	}
+
+	if _, ok := publisher.commands[0].Body.(ReserveStockCommand); !ok {
+		t.Fatal("Expected the reloaded command body to be decoded but got", publisher.commands[0].Body)
+	}
+
+	if state, _ := reopened.GetSaga("order", "cid:1"); len(state.PendingCommands) != 0 || state.Version != 2 {
+		t.Fatal("Expected the published outbox to be cleared but got", state)
+	}
+}
@@ -0,0 +1,269 @@
+package cqrs
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStreamBufferSize is the number of events buffered for a stream subscription when no buffer size is given
+const DefaultStreamBufferSize = 64
+
+// ErrStreamSubscriberBehind closes a stream subscription whose subscriber fell behind by more than its buffer
+var ErrStreamSubscriberBehind = errors.New("stream subscriber fell behind")
+
+// StreamFilter selects the saved events delivered to a stream subscription.
+// SourceID selects the events of a single aggregate. Category selects the events of every aggregate whose ID starts with it,
+// such as "account-" for aggregates identified as "account-<uuid>". An empty filter selects every event
+type StreamFilter struct {
+	SourceID string
+	Category string
+}
+
+// Matches reports whether an event is selected by the filter
+func (f StreamFilter) Matches(event VersionedEvent) bool {
+	if f.SourceID != "" && event.SourceID != f.SourceID {
+		return false
+	}
+
+	return strings.HasPrefix(event.SourceID, f.Category)
+}
+
+// EventStreamSubscriber is implemented by event stores able to push newly saved events to subscribers
+type EventStreamSubscriber interface {
+	SubscribeStream(filter StreamFilter, bufferSize int) *StreamSubscription
+}
+
+// StreamSubscription delivers events matching its filter as soon as they are saved.
+// Rather than blocking the event store or skipping events, a subscriber falling behind by more than its buffer is closed with ErrStreamSubscriberBehind
+// and should resubscribe after reading the events it missed from the event store
+type StreamSubscription struct {
+	filter StreamFilter
+	events chan VersionedEvent
+	lock   sync.Mutex
+	closed bool
+	err    error
+	cancel func()
+}
+
+func newStreamSubscription(filter StreamFilter, bufferSize int) *StreamSubscription {
+	if bufferSize < 1 {
+		bufferSize = DefaultStreamBufferSize
+	}
+
+	return &StreamSubscription{filter: filter, events: make(chan VersionedEvent, bufferSize)}
+}
+
+// Events returns the channel delivering the subscribed events. It is closed after unsubscribing or when the subscription fails
+func (s *StreamSubscription) Events() <-chan VersionedEvent {
+	return s.events
+}
+
+// Err returns the reason the events channel was closed, such as ErrStreamSubscriberBehind. It is nil while subscribed and after unsubscribing
+func (s *StreamSubscription) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+// Unsubscribe stops delivering events and closes the events channel
+func (s *StreamSubscription) Unsubscribe() {
+	s.close(nil)
+}
+
+// close closes the events channel recording why, and stops delivering events
+func (s *StreamSubscription) close(err error) {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+
+	s.closed = true
+	s.err = err
+	close(s.events)
+	cancel := s.cancel
+	s.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// deliver sends an event matching the filter without blocking, closing the subscription when its buffer is full
+func (s *StreamSubscription) deliver(event VersionedEvent) {
+	if !s.filter.Matches(event) {
+		return
+	}
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+
+	select {
+	case s.events <- event:
+		s.lock.Unlock()
+		return
+	default:
+	}
+	s.lock.Unlock()
+
+	PackageLogger().Debugf("StreamSubscription.Behind: %s %s", event.SourceID, event.ID)
+	s.close(ErrStreamSubscriberBehind)
+}
+
+// SubscribeStreamFunc calls handler with every event delivered to the subscription on a dedicated go routine.
+// Unsubscribing the returned subscription stops calling the handler, check the subscription's Err to tell whether it failed
+func SubscribeStreamFunc(subscription *StreamSubscription, handler func(VersionedEvent)) Subscription {
+	go func() {
+		for event := range subscription.Events() {
+			handler(event)
+		}
+	}()
+
+	return subscription
+}
+
+// streamBroadcaster delivers saved events to the stream subscriptions of an event store
+type streamBroadcaster struct {
+	lock          sync.Mutex
+	subscriptions map[*StreamSubscription]bool
+}
+
+func (b *streamBroadcaster) subscribe(filter StreamFilter, bufferSize int) *StreamSubscription {
+	subscription := newStreamSubscription(filter, bufferSize)
+	subscription.cancel = func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.subscriptions, subscription)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subscriptions == nil {
+		b.subscriptions = make(map[*StreamSubscription]bool)
+	}
+
+	b.subscriptions[subscription] = true
+
+	return subscription
+}
+
+func (b *streamBroadcaster) publish(events []VersionedEvent) {
+	b.lock.Lock()
+	subscriptions := make([]*StreamSubscription, 0, len(b.subscriptions))
+	for subscription := range b.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	b.lock.Unlock()
+
+	for _, event := range events {
+		for _, subscription := range subscriptions {
+			subscription.deliver(event)
+		}
+	}
+}
+
+// SubscribeEventStream subscribes to the events saved to a repository matching the filter.
+// Repositories implementing EventStreamSubscriber push saved events, others are polled at the given interval
+func SubscribeEventStream(repository EventStreamRepository, filter StreamFilter, pollInterval time.Duration, bufferSize int) *StreamSubscription {
+	if subscriber, ok := repository.(EventStreamSubscriber); ok {
+		return subscriber.SubscribeStream(filter, bufferSize)
+	}
+
+	return PollEventStream(repository, filter, pollInterval, bufferSize)
+}
+
+// PollEventStream provides stream subscriptions for event stores unable to push saved events by polling the repository.
+// Filters selecting a single aggregate poll its event stream, other filters poll the integration event log.
+// Only events saved after subscribing are delivered
+func PollEventStream(repository EventStreamRepository, filter StreamFilter, interval time.Duration, bufferSize int) *StreamSubscription {
+	subscription := newStreamSubscription(filter, bufferSize)
+	stop := make(chan struct{})
+	subscription.cancel = func() { close(stop) }
+
+	poll := pollIntegrationEvents(repository)
+	if filter.SourceID != "" {
+		poll = pollSourceEvents(repository, filter.SourceID)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				events, err := poll()
+				if err != nil {
+					PackageLogger().Debugf("PollEventStream.Error: %v", err)
+					continue
+				}
+
+				for _, event := range events {
+					subscription.deliver(event)
+				}
+			}
+		}
+	}()
+
+	return subscription
+}
+
+// pollSourceEvents returns a function returning the events saved to an aggregate's stream since the previous call.
+// Aggregates that cannot be retrieved yet are polled from their first version
+func pollSourceEvents(repository EventStreamRepository, sourceID string) func() ([]VersionedEvent, error) {
+	version := 0
+	if events, err := repository.Get(sourceID, 0); err == nil {
+		version = lastVersion(events, version)
+	}
+
+	return func() ([]VersionedEvent, error) {
+		events, err := repository.Get(sourceID, version+1)
+		if err != nil {
+			return nil, err
+		}
+
+		version = lastVersion(events, version)
+		return events, nil
+	}
+}
+
+func lastVersion(events []VersionedEvent, version int) int {
+	for _, event := range events {
+		if event.Version > version {
+			version = event.Version
+		}
+	}
+
+	return version
+}
+
+// pollIntegrationEvents returns a function returning the events appended to the integration event log since the previous call
+func pollIntegrationEvents(log VersionedEventPublicationLogger) func() ([]VersionedEvent, error) {
+	var checkpoint Checkpoint
+	if events, err := log.AllIntegrationEventsEverPublished(); err == nil {
+		for _, event := range events {
+			checkpoint = checkpoint.advance(event.ID)
+		}
+	}
+
+	return func() ([]VersionedEvent, error) {
+		events, err := log.AllIntegrationEventsEverPublished()
+		if err != nil {
+			return nil, err
+		}
+
+		events = events[checkpoint.resumeIndex(events):]
+		for _, event := range events {
+			checkpoint = checkpoint.advance(event.ID)
+		}
+
+		return events, nil
+	}
+}
@@ -0,0 +1,123 @@
+package cqrs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+// pollingOnlyRepository hides the stream subscription support of the wrapped repository
+type pollingOnlyRepository struct {
+	cqrs.EventStreamRepository
+}
+
+func saveStreamEvent(t *testing.T, repository cqrs.EventStreamRepository, sourceID string, version int) {
+	event := cqrs.VersionedEvent{ID: cqrs.NewUUIDString(), SourceID: sourceID, Version: version, Created: time.Now()}
+	if err := repository.Save(sourceID, []cqrs.VersionedEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectStreamEvent(t *testing.T, subscription *cqrs.StreamSubscription, sourceID string) {
+	select {
+	case event := <-subscription.Events():
+		if event.SourceID != sourceID {
+			t.Fatal("Expected an event from", sourceID, "but got", event.SourceID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for an event from", sourceID)
+	}
+}
+
+func TestInMemoryEventStreamSubscriptions(t *testing.T) {
+	repository := cqrs.NewInMemoryEventStreamRepository()
+	aggregate := repository.SubscribeStream(cqrs.StreamFilter{SourceID: "account-1"}, 0)
+	category := repository.SubscribeStream(cqrs.StreamFilter{Category: "account-"}, 0)
+	defer category.Unsubscribe()
+
+	saveStreamEvent(t, repository, "account-1", 1)
+	saveStreamEvent(t, repository, "user-1", 1)
+	saveStreamEvent(t, repository, "account-2", 1)
+
+	expectStreamEvent(t, aggregate, "account-1")
+	expectStreamEvent(t, category, "account-1")
+	expectStreamEvent(t, category, "account-2")
+
+	aggregate.Unsubscribe()
+	saveStreamEvent(t, repository, "account-1", 2)
+	if _, ok := <-aggregate.Events(); ok {
+		t.Fatal("Expected no events after unsubscribing")
+	}
+
+	expectStreamEvent(t, category, "account-1")
+}
+
+func TestPolledEventStreamSubscriptions(t *testing.T) {
+	repository := pollingOnlyRepository{cqrs.NewInMemoryEventStreamRepository()}
+	saveStreamEvent(t, repository, "account-1", 1)
+
+	aggregate := cqrs.SubscribeEventStream(repository, cqrs.StreamFilter{SourceID: "account-1"}, 10*time.Millisecond, 0)
+	defer aggregate.Unsubscribe()
+	category := cqrs.SubscribeEventStream(repository, cqrs.StreamFilter{Category: "account-"}, 10*time.Millisecond, 0)
+	defer category.Unsubscribe()
+
+	saveStreamEvent(t, repository, "user-1", 1)
+	saveStreamEvent(t, repository, "account-1", 2)
+
+	expectStreamEvent(t, aggregate, "account-1")
+	expectStreamEvent(t, category, "account-1")
+
+	select {
+	case event := <-aggregate.Events():
+		t.Fatal("Expected only events saved after subscribing but got", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEventStreamSubscriptionsDeliverIntegrationEvents(t *testing.T) {
+	repository := cqrs.NewInMemoryEventStreamRepository()
+	native := cqrs.SubscribeEventStream(repository, cqrs.StreamFilter{}, 10*time.Millisecond, 0)
+	defer native.Unsubscribe()
+	polled := cqrs.SubscribeEventStream(pollingOnlyRepository{repository}, cqrs.StreamFilter{}, 10*time.Millisecond, 0)
+	defer polled.Unsubscribe()
+
+	errorEvent := cqrs.VersionedEvent{ID: cqrs.NewUUIDString(), EventType: cqrs.CQRSErrorEventType, Created: time.Now()}
+	if err := repository.SaveIntegrationEvent(errorEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, subscription := range []*cqrs.StreamSubscription{native, polled} {
+		select {
+		case event := <-subscription.Events():
+			if event.ID != errorEvent.ID {
+				t.Fatal("Expected the error event but got", event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for the error event")
+		}
+	}
+}
+
+func TestEventStreamSubscriptionClosedWhenBehind(t *testing.T) {
+	repository := cqrs.NewInMemoryEventStreamRepository()
+	subscription := repository.SubscribeStream(cqrs.StreamFilter{SourceID: "account-1"}, 1)
+
+	saveStreamEvent(t, repository, "account-1", 1)
+	saveStreamEvent(t, repository, "account-1", 2)
+
+	expectStreamEvent(t, subscription, "account-1")
+	if _, ok := <-subscription.Events(); ok {
+		t.Fatal("Expected the subscription to be closed once its buffer overflowed")
+	}
+
+	if err := subscription.Err(); err != cqrs.ErrStreamSubscriberBehind {
+		t.Fatal("Expected the subscription to report falling behind but got", err)
+	}
+
+	unsubscribed := repository.SubscribeStream(cqrs.StreamFilter{}, 0)
+	unsubscribed.Unsubscribe()
+	if err := unsubscribed.Err(); err != nil {
+		t.Fatal("Expected no error after unsubscribing but got", err)
+	}
+}
@@ -0,0 +1,23 @@
+package cqrs
+
+import "sync"
+
+// Subscription is returned when registering a handler. Unsubscribing removes the handler from its dispatcher
+type Subscription interface {
+	Unsubscribe()
+}
+
+// subscription runs its unsubscribe function at most once
+type subscription struct {
+	once        sync.Once
+	unsubscribe func()
+}
+
+func newSubscription(unsubscribe func()) *subscription {
+	return &subscription{unsubscribe: unsubscribe}
+}
+
+// Unsubscribe removes the handler. Calling it more than once has no effect
+func (s *subscription) Unsubscribe() {
+	s.once.Do(s.unsubscribe)
+}
@@ -8,8 +8,12 @@ import (
 
 var methodHandlerPrefix = "Handle"
 
+var versionedEventType = reflect.TypeOf(VersionedEvent{})
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 // HandlersCache is a map of types to functions that will be used to route event sourcing events
-type HandlersCache map[reflect.Type]func(source interface{}, event interface{})
+type HandlersCache map[reflect.Type]func(source interface{}, event VersionedEvent) error
 
 // TypeCache is a map of strings to reflect.Type structures
 type TypeCache map[string]reflect.Type
@@ -105,26 +109,75 @@ func (r *defaultTypeRegistry) RegisterEvents(events ...interface{}) {
 func createHandlersCache(source interface{}) HandlersCache {
 	sourceType := reflect.TypeOf(source)
 	handlers := make(HandlersCache)
+	pointerHandlers := make(HandlersCache)
 
 	methodCount := sourceType.NumMethod()
 	for i := 0; i < methodCount; i++ {
 		method := sourceType.Method(i)
 
-		if strings.HasPrefix(method.Name, methodHandlerPrefix) {
-			//   func (source *MySource) HandleMyEvent(e MyEvent).
-			if method.Type.NumIn() == 2 {
-				eventType := method.Type.In(1)
-				handler := func(source interface{}, event interface{}) {
-					sourceValue := reflect.ValueOf(source)
-					eventValue := reflect.ValueOf(event)
+		if !strings.HasPrefix(method.Name, methodHandlerPrefix) {
+			continue
+		}
+
+		//   func (source *MySource) HandleMyEvent(e MyEvent)
+		//   func (source *MySource) HandleMyEvent(e *MyEvent) error
+		//   func (source *MySource) HandleMyEvent(e MyEvent, v cqrs.VersionedEvent) error
+		methodType := method.Type
+		if methodType.NumIn() < 2 || methodType.NumIn() > 3 {
+			continue
+		}
 
-					method.Func.Call([]reflect.Value{sourceValue, eventValue})
-				}
+		if methodType.NumIn() == 3 && methodType.In(2) != versionedEventType {
+			continue
+		}
 
-				handlers[eventType] = handler
-			}
+		if methodType.NumOut() > 1 || (methodType.NumOut() == 1 && methodType.Out(0) != errorType) {
+			continue
+		}
+
+		eventType := methodType.In(1)
+		handler := createHandler(method)
+
+		handlers[eventType] = handler
+		if eventType.Kind() == reflect.Ptr {
+			// Allow events raised by value to be routed to handlers receiving a pointer
+			pointerHandlers[eventType.Elem()] = handler
+		}
+	}
+
+	for eventType, handler := range pointerHandlers {
+		if _, ok := handlers[eventType]; !ok {
+			handlers[eventType] = handler
 		}
 	}
 
 	return handlers
 }
+
+func createHandler(method reflect.Method) func(source interface{}, event VersionedEvent) error {
+	methodType := method.Type
+	eventType := methodType.In(1)
+	passVersionedEvent := methodType.NumIn() == 3
+	returnsError := methodType.NumOut() == 1
+
+	return func(source interface{}, event VersionedEvent) error {
+		eventValue := reflect.ValueOf(event.Event)
+		if eventType.Kind() == reflect.Ptr && eventValue.Type() != eventType {
+			pointerValue := reflect.New(eventType.Elem())
+			pointerValue.Elem().Set(eventValue)
+			eventValue = pointerValue
+		}
+
+		arguments := []reflect.Value{reflect.ValueOf(source), eventValue}
+		if passVersionedEvent {
+			arguments = append(arguments, reflect.ValueOf(event))
+		}
+
+		results := method.Func.Call(arguments)
+		if !returnsError || results[0].IsNil() {
+			return nil
+		}
+
+		return results[0].Interface().(error)
+	}
+}
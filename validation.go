@@ -0,0 +1,70 @@
+package cqrs
+
+import (
+	"strings"
+)
+
+// ValidatableCommand is implemented by command bodies able to validate themselves before any command handler runs
+type ValidatableCommand interface {
+	Validate() []FieldError
+}
+
+// FieldError describes why a single field of a command failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a command fails validation
+type ValidationError struct {
+	CommandType   string
+	CorrelationID string
+	Fields        []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = field.Field + ": " + field.Message
+	}
+
+	return "command " + e.CommandType + " failed validation - " + strings.Join(fields, "; ")
+}
+
+// ValidateCommand validates a command whose body implements ValidatableCommand.
+// A *ValidationError is returned when the command is invalid
+func ValidateCommand(command Command) error {
+	validatable, ok := command.Body.(ValidatableCommand)
+	if !ok {
+		return nil
+	}
+
+	fields := validatable.Validate()
+	if len(fields) == 0 {
+		return nil
+	}
+
+	metricsCommandsInvalid.WithLabelValues(command.CommandType).Inc()
+	return &ValidationError{CommandType: command.CommandType, CorrelationID: command.CorrelationID, Fields: fields}
+}
+
+// ValidatingCommandPublisher is a CommandPublisher that validates commands before handing them to another publisher
+type ValidatingCommandPublisher struct {
+	publisher CommandPublisher
+}
+
+// NewValidatingCommandPublisher is a constructor for the ValidatingCommandPublisher
+func NewValidatingCommandPublisher(publisher CommandPublisher) *ValidatingCommandPublisher {
+	return &ValidatingCommandPublisher{publisher}
+}
+
+// PublishCommands validates all commands and publishes them only if every command is valid
+func (p *ValidatingCommandPublisher) PublishCommands(commands []Command) error {
+	for _, command := range commands {
+		if err := ValidateCommand(command); err != nil {
+			return err
+		}
+	}
+
+	return p.publisher.PublishCommands(commands)
+}
@@ -0,0 +1,103 @@
+package cqrs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andrewwebber/cqrs"
+)
+
+type OpenLedgerCommand struct {
+	Owner string
+}
+
+func (command OpenLedgerCommand) Validate() []cqrs.FieldError {
+	if len(command.Owner) == 0 {
+		return []cqrs.FieldError{{Field: "Owner", Message: "is required"}}
+	}
+
+	return nil
+}
+
+func TestCommandDispatchManagerValidation(t *testing.T) {
+	typeRegistry := cqrs.NewTypeRegistry()
+	repository := cqrs.NewRepository(cqrs.NewInMemoryEventStreamRepository(), typeRegistry)
+	bus := cqrs.NewInMemoryCommandBus()
+	bus.SetCommandTimeout(5 * time.Second)
+	deadLetters := cqrs.NewInMemoryDeadLetterStore()
+	bus.SetDeadLetterStore(deadLetters)
+
+	manager := cqrs.NewCommandDispatchManager(bus, typeRegistry)
+	manager.SetErrorRepository(repository)
+	handled := false
+	manager.RegisterCommandHandler(OpenLedgerCommand{}, func(command cqrs.Command) error {
+		handled = true
+		return nil
+	})
+
+	stop := make(chan bool)
+	if _, err := manager.Listen(stop, false, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	command := cqrs.CreateCommand(OpenLedgerCommand{})
+	result, err := bus.SendCommand(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Success || handled {
+		t.Fatal("Expected invalid command to be rejected before any handler runs")
+	}
+
+	events, err := repository.GetEventStreamRepository().GetIntegrationEventsByCorrelationID(command.CorrelationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].EventType != cqrs.CQRSErrorEventType {
+		t.Fatal("Expected a CQRS error event to be recorded against the correlation ID", events)
+	}
+
+	errorEvent := events[0].Event.(cqrs.ErrorEvent)
+	if len(errorEvent.Fields) != 1 || errorEvent.Fields[0].Field != "Owner" {
+		t.Fatal("Expected structured field errors", errorEvent)
+	}
+
+	if stored, _ := deadLetters.GetDeadLetters(); len(stored) != 1 || !stored[0].Permanent {
+		t.Fatal("Expected the invalid command to be dead lettered as a permanent failure", stored)
+	}
+
+	stop <- true
+}
+
+func TestValidatingCommandPublisher(t *testing.T) {
+	publisher := cqrs.NewValidatingCommandPublisher(cqrs.NewInMemoryCommandBus())
+	err := publisher.PublishCommands([]cqrs.Command{cqrs.CreateCommand(OpenLedgerCommand{})})
+	validationErr, ok := err.(*cqrs.ValidationError)
+	if !ok {
+		t.Fatal("Expected a validation error but got", err)
+	}
+
+	if validationErr.Fields[0].Message != "is required" {
+		t.Fatal("Unexpected field error", validationErr.Fields)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	err := cqrs.NewPermanentError(&cqrs.ValidationError{CommandType: "OpenLedgerCommand"})
+	if !cqrs.IsPermanent(fmt.Errorf("dispatching: %w", err)) {
+		t.Fatal("Expected wrapped permanent errors to be detected")
+	}
+
+	if cqrs.NewPermanentError(err) != err || cqrs.NewPermanentError(nil) != nil {
+		t.Fatal("Expected permanent and nil errors to be returned unchanged")
+	}
+
+	var validationErr *cqrs.ValidationError
+	if !errors.As(err, &validationErr) || cqrs.IsPermanent(validationErr) {
+		t.Fatal("Expected the validation error to be unwrapped from the permanent error")
+	}
+}